@@ -0,0 +1,89 @@
+package agenttest
+
+import (
+	"testing"
+
+	. "hive-arena/common"
+)
+
+// Helpers for unit tests of agent decisions, in the usual go test files of an
+// agent. They report failures through t, and go on.
+
+// Runs think on the player's view of a position, and returns its orders. The
+// position is left unchanged.
+
+func Decide(t testing.TB, think Think, state *GameState, player int) []Order {
+	t.Helper()
+
+	orders, err := FromThink(think).Think(View(state, player), player)
+	if err != nil {
+		t.Fatalf("think failed: %s", err)
+	}
+
+	result := make([]Order, len(orders))
+	for i, order := range orders {
+		result[i] = *order
+	}
+	return result
+}
+
+// The first order given to the unit at coords
+
+func OrderFor(orders []Order, coords Coords) (Order, bool) {
+	for _, order := range orders {
+		if order.Coords == coords {
+			return order, true
+		}
+	}
+	return Order{}, false
+}
+
+// Checks that the unit at want.Coords is given an order of the same type, and
+// in the same direction for the orders that have one
+
+func AssertOrder(t testing.TB, orders []Order, want Order) {
+	t.Helper()
+
+	order, found := OrderFor(orders, want.Coords)
+	if !found {
+		t.Errorf("no order for %s, want %s %s", want.Coords, want.Type, want.Direction)
+		return
+	}
+	if order.Type != want.Type || (hasDirection(want) && order.Direction != want.Direction) {
+		t.Errorf("order for %s is %s %s, want %s %s", want.Coords, order.Type, order.Direction, want.Type, want.Direction)
+	}
+}
+
+func hasDirection(order Order) bool {
+	return order.Type != FORAGE && order.Type != BUILD_HIVE
+}
+
+func AssertNoOrder(t testing.TB, orders []Order, coords Coords) {
+	t.Helper()
+
+	if order, found := OrderFor(orders, coords); found {
+		t.Errorf("unexpected order for %s: %s %s", coords, order.Type, order.Direction)
+	}
+}
+
+// Checks that the orders all succeed when played on the position by the player
+// alone, by their status as the server would report it
+
+func AssertValid(t testing.TB, state *GameState, player int, orders []Order) {
+	t.Helper()
+
+	all := make([][]*Order, state.NumPlayers)
+	all[player] = pointers(orders)
+
+	processed, err := state.Clone().ProcessOrders(all)
+	if err != nil {
+		t.Errorf("could not play orders: %s", err)
+		return
+	}
+
+	for _, order := range processed {
+		if order.Status != OK {
+			t.Errorf("order %s %s for %s failed: %s", order.Type, order.Direction, order.Coords, order.Status)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package agenttest
+
+import (
+	"encoding/json"
+	"fmt"
+
+	. "hive-arena/common"
+	"hive-arena/match"
+)
+
+// The callback of the Go template, as passed to Run in example-agent-go
+
+type Think func(state *GameState, player int) []Order
+
+// Plays a template callback as an in-process agent
+
+type thinkAgent Think
+
+func (think thinkAgent) Think(view *GameState, player int) ([]*Order, error) {
+	return pointers(think(view, player)), nil
+}
+
+func FromThink(think Think) Agent {
+	return thinkAgent(think)
+}
+
+// An opponent that plays fixed orders, by game turn, and nothing on the other
+// turns. A nil Scripted stays idle.
+
+type Scripted map[uint][]Order
+
+func (script Scripted) Think(view *GameState, player int) ([]*Order, error) {
+	return pointers(script[view.Turn]), nil
+}
+
+func pointers(orders []Order) []*Order {
+	result := make([]*Order, len(orders))
+	for i := range orders {
+		order := orders[i]
+		result[i] = &order
+	}
+	return result
+}
+
+// The view of a player, as the /game route sends it: the player's fog of war,
+// decoded from JSON, so that the agent cannot change the game through it
+
+func View(state *GameState, player int) *GameState {
+	data, err := json.Marshal(state.PlayerView(player))
+	if err != nil {
+		panic(err)
+	}
+
+	var view GameState
+	if err := json.Unmarshal(data, &view); err != nil {
+		panic(err)
+	}
+	return &view
+}
+
+// A game played locally, one agent per player, with no server. Agents think
+// one after the other, on their view of the game, and the turn is processed
+// like on the server.
+
+type Game struct {
+	State   *GameState
+	Agents  []Agent
+	History []Turn
+	Crashes []match.Crash
+}
+
+func NewGame(mapdata MapData, seed int64, agents ...Agent) (*Game, error) {
+	state := NewGameState(mapdata, len(agents))
+	if state == nil {
+		return nil, fmt.Errorf("invalid number of players: %d", len(agents))
+	}
+	return FromState(state, seed, agents...)
+}
+
+// Starts a game from any position, such as one built with Position. The state
+// is copied.
+
+func FromState(state *GameState, seed int64, agents ...Agent) (*Game, error) {
+	if len(agents) != state.NumPlayers {
+		return nil, fmt.Errorf("expected %d agents, got %d", state.NumPlayers, len(agents))
+	}
+
+	state = state.Clone()
+	state.SetSeed(seed)
+
+	return &Game{
+		State:   state,
+		Agents:  agents,
+		History: []Turn{{State: state.Clone()}},
+	}, nil
+}
+
+// Plays one turn, and returns the orders as processed, with their status.
+// Agents that return an error or panic play no orders, and are recorded in
+// Crashes.
+
+func (game *Game) Step() ([]*Order, error) {
+	orders := make([][]*Order, len(game.Agents))
+	for player, agent := range game.Agents {
+		if game.State.HasForfeited(player) {
+			continue
+		}
+
+		var err error
+		orders[player], err = think(agent, View(game.State, player), player)
+		if err != nil {
+			orders[player] = nil
+			game.Crashes = append(game.Crashes, match.Crash{Player: player, Turn: game.State.Turn, Error: err.Error()})
+		}
+	}
+
+	processed, err := game.State.ProcessOrders(orders)
+	if err != nil {
+		return nil, err
+	}
+
+	game.History = append(game.History, Turn{Orders: processed, State: game.State.Clone()})
+	return processed, nil
+}
+
+// Plays up to the given number of turns, or until the game is over if turns
+// is 0
+
+func (game *Game) Play(turns int) error {
+	for i := 0; !game.State.GameOver && (turns == 0 || i < turns); i++ {
+		if _, err := game.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func think(agent Agent, view *GameState, player int) (orders []*Order, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+
+	return agent.Think(view, player)
+}
@@ -0,0 +1,68 @@
+package agenttest
+
+import (
+	"fmt"
+
+	. "hive-arena/common"
+)
+
+var charToTerrain = map[byte]Terrain{
+	'.': EMPTY,
+	'F': FIELD,
+	'R': ROCK,
+}
+
+var charToEntity = map[byte]EntityType{
+	'H': HIVE,
+	'B': BEE,
+	'b': BEE,
+	'W': WALL,
+}
+
+// Builds a position from rows drawn like the maps, and like the game is
+// printed: '.', 'F' and 'R' are empty hexes, fields and rocks, and 'H', 'B'
+// and 'W' followed by a player ID are hives, bees and walls on empty hexes. A
+// 'b' is a bee carrying a flower. Fields are full, and players have no flowers,
+// which tests can change on the returned state. Panics on invalid rows.
+
+func Position(players int, rows ...string) *GameState {
+	if !IsValidNumPlayers(players) {
+		panic(fmt.Sprintf("invalid number of players: %d", players))
+	}
+
+	state := &GameState{
+		NumPlayers:      players,
+		Hexes:           make(map[Coords]*Hex),
+		PlayerResources: make([]uint, players),
+	}
+
+	for row, line := range rows {
+		for col := 0; col < len(line); col++ {
+			coords := Coords{Row: row, Col: col / 2}
+			char := line[col]
+
+			if terrain, ok := charToTerrain[char]; ok {
+				hex := &Hex{Terrain: terrain}
+				if terrain == FIELD {
+					hex.Resources = INIT_FIELD_FLOWERS
+				}
+				state.Hexes[coords] = hex
+				continue
+			}
+
+			kind, ok := charToEntity[char]
+			if !ok {
+				continue
+			}
+
+			if col+1 >= len(line) || line[col+1] < '0' || int(line[col+1]-'0') >= players {
+				panic(fmt.Sprintf("invalid player for %c at row %d, column %d", char, row, col))
+			}
+			entity := &Entity{Type: kind, Player: int(line[col+1] - '0'), HasFlower: char == 'b'}
+			state.Hexes[coords] = &Hex{Terrain: EMPTY, Entity: entity}
+			col++
+		}
+	}
+
+	return state
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	. "hive-arena/common"
+	"hive-arena/match"
+)
+
+// Two-sided 95% normal quantile
+const Z95 = 1.96
+
+// One game between agents A and B
+
+type BenchGame struct {
+	Map     string `json:"map"`
+	Seed    int64  `json:"seed"`
+	SeatA   int    `json:"seatA"`
+	Winner  string `json:"winner"`
+	Margin  int    `json:"margin"`
+	Crashes int    `json:"crashes"`
+}
+
+// Results of A against B over a set of games. Draws count as half a win in
+// the score. Intervals are 95% confidence intervals: Wilson for the score,
+// normal approximation for the mean flower margin of A over B.
+
+type BenchStats struct {
+	Games      int        `json:"games"`
+	WinsA      int        `json:"winsA"`
+	WinsB      int        `json:"winsB"`
+	Draws      int        `json:"draws"`
+	ScoreA     float64    `json:"scoreA"`
+	ScoreCI    [2]float64 `json:"scoreCI"`
+	MeanMargin float64    `json:"meanMargin"`
+	MarginCI   [2]float64 `json:"marginCI"`
+	Crashes    int        `json:"crashes"`
+	margins    []float64
+}
+
+func (stats *BenchStats) add(game BenchGame) {
+	stats.Games++
+	stats.Crashes += game.Crashes
+	switch game.Winner {
+	case "A":
+		stats.WinsA++
+	case "B":
+		stats.WinsB++
+	default:
+		stats.Draws++
+	}
+	stats.margins = append(stats.margins, float64(game.Margin))
+}
+
+func (stats *BenchStats) finish() {
+	n := float64(stats.Games)
+	if n == 0 {
+		return
+	}
+
+	stats.ScoreA = (float64(stats.WinsA) + float64(stats.Draws)/2) / n
+	stats.ScoreCI = wilson(stats.ScoreA, n)
+	stats.MeanMargin, stats.MarginCI = meanInterval(stats.margins)
+}
+
+func wilson(p float64, n float64) [2]float64 {
+	z2 := Z95 * Z95
+	center := (p + z2/(2*n)) / (1 + z2/n)
+	spread := Z95 / (1 + z2/n) * math.Sqrt(p*(1-p)/n+z2/(4*n*n))
+	return [2]float64{center - spread, center + spread}
+}
+
+func meanInterval(values []float64) (float64, [2]float64) {
+	n := float64(len(values))
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= n
+
+	if n < 2 {
+		return mean, [2]float64{mean, mean}
+	}
+
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= n - 1
+
+	spread := Z95 * math.Sqrt(variance/n)
+	return mean, [2]float64{mean - spread, mean + spread}
+}
+
+// Games are played in pairs with the same map and seed, A and B swapping seats
+
+func playBenchGame(index int, agents [2]string, maps []string, mapDir string, seed int64, timeout time.Duration) (BenchGame, error) {
+	pair := index / 2
+	game := BenchGame{
+		Map:   maps[pair%len(maps)],
+		Seed:  seed + int64(pair),
+		SeatA: index % 2,
+	}
+
+	mapdata, err := loadMap(mapDir, game.Map)
+	if err != nil {
+		return game, err
+	}
+
+	specs := []string{agents[0], agents[1]}
+	if game.SeatA == 1 {
+		specs = []string{agents[1], agents[0]}
+	}
+
+	var seats []match.Seat
+	defer func() { closeSeats(seats) }()
+
+	for i, spec := range specs {
+		seat, err := newSeat(spec, game.Seed+int64(i), timeout)
+		if err != nil {
+			return game, err
+		}
+		seats = append(seats, seat)
+	}
+
+	config := match.Config{
+		Map:     game.Map,
+		MapData: mapdata,
+		Seed:    game.Seed,
+		Timing:  TurnTiming{TurnTimeout: Duration(timeout)},
+	}
+	result, err := match.Run(config, seats)
+	if err != nil {
+		return game, err
+	}
+
+	final := result.Final()
+	ranks := make([]Rank, 2)
+	for _, rank := range final.Ranking {
+		ranks[rank.Player] = rank
+	}
+	a, b := ranks[game.SeatA], ranks[1-game.SeatA]
+
+	// Games tied for the win are draws, as in tournaments and stats
+
+	if len(final.Winners) == 1 {
+		game.Winner = "A"
+		if final.Winners[0] != game.SeatA {
+			game.Winner = "B"
+		}
+	}
+	game.Margin = int(a.Flowers) - int(b.Flowers)
+	game.Crashes = len(result.Crashes)
+
+	return game, nil
+}
+
+func runBench(args []string) int {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	games := flags.Int("games", 20, "number of games, rounded up to an even number so that both agents play both seats")
+	mapList := flags.String("map", "", "comma-separated list of maps (all maps if empty)")
+	mapDir := flags.String("maps", "maps", "directory containing the maps")
+	seed := flags.Int64("seed", 1, "seed of the first pair of games, incremented for each pair")
+	parallel := flags.Int("parallel", runtime.NumCPU(), "number of games played at the same time")
+	timeout := flags.Duration("turn-timeout", 2*time.Second, "time an agent command has to answer each turn before being killed")
+	asJson := flags.Bool("json", false, "print the results as JSON, including every game")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: arena bench [options] <agent A> <agent B>")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Plays 2-player games between A and B, and reports how A does against B.")
+		fmt.Fprintln(os.Stderr, "Agents are built-in bots or commands, as in 'arena match'.")
+		fmt.Fprintln(os.Stderr)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 || *games < 1 || *parallel < 1 {
+		flags.Usage()
+		return 2
+	}
+	agents := [2]string{flags.Arg(0), flags.Arg(1)}
+
+	var maps []string
+	if *mapList != "" {
+		maps = strings.Split(*mapList, ",")
+	} else {
+		paths, _ := filepath.Glob(filepath.Join(*mapDir, "*.txt"))
+		for _, path := range paths {
+			maps = append(maps, strings.TrimSuffix(filepath.Base(path), ".txt"))
+		}
+	}
+	if len(maps) == 0 {
+		fmt.Fprintln(os.Stderr, "No maps found")
+		return 2
+	}
+
+	count := *games + *games%2
+	results := make([]BenchGame, count)
+	errors := make([]error, count)
+
+	indexes := make(chan int)
+	var group sync.WaitGroup
+	for range min(*parallel, count) {
+		group.Go(func() {
+			for index := range indexes {
+				results[index], errors[index] = playBenchGame(index, agents, maps, *mapDir, *seed, *timeout)
+			}
+		})
+	}
+	for index := range count {
+		indexes <- index
+	}
+	close(indexes)
+	group.Wait()
+
+	for _, err := range errors {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not play game:", err)
+			return 1
+		}
+	}
+
+	total := &BenchStats{}
+	byMap := make(map[string]*BenchStats)
+	bySeat := [2]*BenchStats{{}, {}}
+
+	for _, game := range results {
+		if byMap[game.Map] == nil {
+			byMap[game.Map] = &BenchStats{}
+		}
+		for _, stats := range []*BenchStats{total, byMap[game.Map], bySeat[game.SeatA]} {
+			stats.add(game)
+		}
+	}
+	for _, stats := range append([]*BenchStats{total, bySeat[0], bySeat[1]}, mapValues(byMap)...) {
+		stats.finish()
+	}
+
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(map[string]any{
+			"agents": agents,
+			"total":  total,
+			"maps":   byMap,
+			"seats":  bySeat,
+			"games":  results,
+		})
+	} else {
+		fmt.Printf("A: %s\nB: %s\n\n", agents[0], agents[1])
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(writer, "\tGames\tA wins\tB wins\tDraws\tA score\t95% CI\tA margin\t95% CI\t")
+		printBenchRow(writer, "All games", total)
+		for _, name := range maps {
+			if stats := byMap[name]; stats != nil {
+				printBenchRow(writer, name, stats)
+			}
+		}
+		printBenchRow(writer, "A in seat 0", bySeat[0])
+		printBenchRow(writer, "A in seat 1", bySeat[1])
+		writer.Flush()
+
+		fmt.Println()
+		fmt.Println("The score counts draws as half a win. The margin is A's flowers minus B's, at the end of the game.")
+	}
+
+	if total.Crashes > 0 {
+		fmt.Fprintf(os.Stderr, "Agents failed %d times\n", total.Crashes)
+		return 1
+	}
+	return 0
+}
+
+func mapValues(byMap map[string]*BenchStats) []*BenchStats {
+	var values []*BenchStats
+	for _, stats := range byMap {
+		values = append(values, stats)
+	}
+	return values
+}
+
+func printBenchRow(writer *tabwriter.Writer, label string, stats *BenchStats) {
+	fmt.Fprintf(writer, "%s\t%d\t%d\t%d\t%d\t%.0f%%\t[%.0f%%, %.0f%%]\t%+.1f\t[%+.1f, %+.1f]\t\n",
+		label, stats.Games, stats.WinsA, stats.WinsB, stats.Draws,
+		stats.ScoreA*100, stats.ScoreCI[0]*100, stats.ScoreCI[1]*100,
+		stats.MeanMargin, stats.MarginCI[0], stats.MarginCI[1])
+}
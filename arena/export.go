@@ -0,0 +1,395 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	. "hive-arena/common"
+	"hive-arena/gym"
+)
+
+// What a player saw on one turn, the orders it gave, and how its game ended
+
+type sample struct {
+	Game    string     `json:"game"`
+	Map     string     `json:"map"`
+	Turn    uint       `json:"turn"`
+	Player  int        `json:"player"`
+	Agent   string     `json:"agent"`
+	View    *GameState `json:"view"`
+	Orders  []*Order   `json:"orders"`
+	Rank    int        `json:"rank"`
+	Won     bool       `json:"won"`
+	Flowers uint       `json:"flowers"`
+
+	gameIndex int
+	parity    int
+}
+
+type exportFilter struct {
+	agents    []string
+	maps      []string
+	minRating float64
+	ratings   *Ratings
+	winners   bool
+	derived   bool
+}
+
+// Aborted and practice games are never exported, as their turns do not all
+// follow from the orders. Games started from a scenario or forked from another
+// game are left out unless asked for, as they do not start from an opening.
+
+func (filter *exportFilter) matchesGame(summary GameSummary) bool {
+	if summary.EndReason == ABORTED || summary.Practice {
+		return false
+	}
+	if !filter.derived && (summary.Scenario != "" || summary.ForkedFrom != nil) {
+		return false
+	}
+	return len(filter.maps) == 0 || slices.Contains(filter.maps, summary.Map)
+}
+
+func (filter *exportFilter) matchesPlayer(summary GameSummary, player int) bool {
+	if len(filter.agents) > 0 && !slices.Contains(filter.agents, summary.Players[player]) {
+		return false
+	}
+	if filter.winners && !slices.Contains(summary.Winners, player) {
+		return false
+	}
+	if filter.ratings != nil {
+		agent, found := filter.ratings.Agents[summary.AgentKey(player)]
+		if !found || agent.Rating < filter.minRating {
+			return false
+		}
+	}
+	return true
+}
+
+type exporter struct {
+	dir       string
+	format    string
+	shardSize int
+	space     gym.ActionSpace
+
+	games   []string
+	samples []sample
+	shards  int
+	total   int
+}
+
+func (exp *exporter) add(s sample) error {
+	exp.samples = append(exp.samples, s)
+	exp.total++
+	if len(exp.samples) >= exp.shardSize {
+		return exp.flush()
+	}
+	return nil
+}
+
+func (exp *exporter) flush() error {
+	if len(exp.samples) == 0 {
+		return nil
+	}
+
+	name := filepath.Join(exp.dir, fmt.Sprintf("shard-%05d", exp.shards))
+	var err error
+	switch exp.format {
+	case "jsonl":
+		err = exp.writeJsonl(name + ".jsonl")
+	case "npy":
+		err = exp.writeNpy(name)
+	case "npz":
+		err = exp.writeNpz(name + ".npz")
+	}
+
+	exp.samples = nil
+	exp.shards++
+	return err
+}
+
+func (exp *exporter) writeJsonl(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	for _, s := range exp.samples {
+		if err := encoder.Encode(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tensor formats encode views and orders like the gym package. The orders of
+// sample i are actions[offsets[i]:offsets[i+1]].
+
+func (exp *exporter) arrays() []npyArray {
+	n := len(exp.samples)
+	size := gym.NUM_CHANNELS * exp.space.Rows * exp.space.Cols
+
+	observations := make([]float32, 0, n*size)
+	offsets := []int64{0}
+	var actions []int32
+	games := make([]int32, n)
+	turns := make([]int32, n)
+	players := make([]int32, n)
+	ranks := make([]int32, n)
+	won := make([]uint8, n)
+	flowers := make([]int32, n)
+
+	for i, s := range exp.samples {
+		space := exp.space
+		space.Parity = s.parity
+		observations = append(observations, space.Observe(s.View, s.Player).Tensor...)
+
+		for _, order := range s.Orders {
+			if action, err := space.Action(order); err == nil {
+				actions = append(actions, int32(action))
+			}
+		}
+		offsets = append(offsets, int64(len(actions)))
+
+		games[i] = int32(s.gameIndex)
+		turns[i] = int32(s.Turn)
+		players[i] = int32(s.Player)
+		ranks[i] = int32(s.Rank)
+		if s.Won {
+			won[i] = 1
+		}
+		flowers[i] = int32(s.Flowers)
+	}
+
+	return []npyArray{
+		{"observations", "<f4", []int{n, gym.NUM_CHANNELS, exp.space.Rows, exp.space.Cols}, observations},
+		{"actions", "<i4", []int{len(actions)}, actions},
+		{"action_offsets", "<i8", []int{n + 1}, offsets},
+		{"game", "<i4", []int{n}, games},
+		{"turn", "<i4", []int{n}, turns},
+		{"player", "<i4", []int{n}, players},
+		{"rank", "<i4", []int{n}, ranks},
+		{"won", "|u1", []int{n}, won},
+		{"flowers", "<i4", []int{n}, flowers},
+	}
+}
+
+func (exp *exporter) writeNpy(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, array := range exp.arrays() {
+		file, err := os.Create(filepath.Join(dir, array.name+".npy"))
+		if err != nil {
+			return err
+		}
+		err = array.write(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (exp *exporter) writeNpz(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	for _, array := range exp.arrays() {
+		entry, err := archive.Create(array.name + ".npy")
+		if err != nil {
+			return err
+		}
+		if err := array.write(entry); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// Describes the encoding of the tensor formats, and the games that the game
+// index of samples refers to
+
+func (exp *exporter) writeMeta() error {
+	meta := map[string]any{
+		"format":        exp.format,
+		"samples":       exp.total,
+		"shards":        exp.shards,
+		"games":         exp.games,
+		"channels":      gym.NUM_CHANNELS,
+		"rows":          exp.space.Rows,
+		"cols":          exp.space.Cols,
+		"actionsPerHex": gym.ACTIONS_PER_HEX,
+	}
+
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(exp.dir, "meta.json"), data, 0644)
+}
+
+// Samples of each selected player on each turn of a game
+
+func (exp *exporter) exportGame(game *PersistedGame, filter *exportFilter) (int, error) {
+	summary := game.Summary()
+	if !filter.matchesGame(summary) || len(game.History) == 0 {
+		return 0, nil
+	}
+
+	parity := 0
+	for coords := range game.History[0].State.Hexes {
+		if !exp.space.Contains(coords) {
+			fmt.Fprintf(os.Stderr, "Skipping %s: map %s does not fit the %dx%d grid\n", game.Id, game.Map, exp.space.Rows, exp.space.Cols)
+			return 0, nil
+		}
+		parity = (coords.Row + coords.Col) % 2
+	}
+
+	ranks := make(map[int]Rank)
+	for _, rank := range summary.Ranking {
+		ranks[rank.Player] = rank
+	}
+
+	count := 0
+	gameIndex := len(exp.games)
+
+	for player := range game.Players {
+		if !filter.matchesPlayer(summary, player) {
+			continue
+		}
+
+		for t := 1; t < len(game.History); t++ {
+			state := game.History[t-1].State
+			if state.HasForfeited(player) {
+				break
+			}
+
+			var orders []*Order
+			for _, order := range game.History[t].Orders {
+				if order.Player == player {
+					orders = append(orders, order)
+				}
+			}
+
+			err := exp.add(sample{
+				Game:      game.Id,
+				Map:       game.Map,
+				Turn:      state.Turn,
+				Player:    player,
+				Agent:     game.Players[player],
+				View:      state.PlayerView(player),
+				Orders:    orders,
+				Rank:      ranks[player].Rank,
+				Won:       slices.Contains(summary.Winners, player),
+				Flowers:   ranks[player].Flowers,
+				gameIndex: gameIndex,
+				parity:    parity,
+			})
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+
+	if count > 0 {
+		exp.games = append(exp.games, game.Id)
+	}
+	return count, nil
+}
+
+func runExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	historyDir := flags.String("history", "history", "directory containing the history files")
+	mapDir := flags.String("maps", "maps", "directory containing the maps, which sets the size of the tensors")
+	out := flags.String("out", "dataset", "directory in which to write the shards")
+	format := flags.String("format", "jsonl", "jsonl, npy (a directory of arrays per shard) or npz")
+	shardSize := flags.Int("shard-size", 1000, "number of samples per shard")
+	agentList := flags.String("agent", "", "comma-separated list of agent names to export (all if empty)")
+	mapList := flags.String("map", "", "comma-separated list of maps to export (all if empty)")
+	minRating := flags.Float64("min-rating", 0, "only export agents with at least this current rating")
+	winners := flags.Bool("winners", false, "only export the players who won their game")
+	derived := flags.Bool("derived", false, "also export games started from a scenario or forked from another game")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: arena export [options]")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Exports the view of each player on each turn of the saved games, along with")
+		fmt.Fprintln(os.Stderr, "the orders they gave and the outcome of the game, for supervised learning.")
+		fmt.Fprintln(os.Stderr)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if !slices.Contains([]string{"jsonl", "npy", "npz"}, *format) || *shardSize < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	filter := &exportFilter{winners: *winners, minRating: *minRating, derived: *derived}
+	if *agentList != "" {
+		filter.agents = strings.Split(*agentList, ",")
+	}
+	if *mapList != "" {
+		filter.maps = strings.Split(*mapList, ",")
+	}
+	if *minRating != 0 {
+		filter.ratings = ComputeRatings(loadSummaries(*historyDir))
+	}
+
+	maps, err := loadMaps(*mapDir, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not load maps:", err)
+		return 2
+	}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not create output directory:", err)
+		return 1
+	}
+
+	exp := &exporter{
+		dir:       *out,
+		format:    *format,
+		shardSize: *shardSize,
+		space:     gym.NewEnv(maps).Space(),
+	}
+
+	paths, _ := filepath.Glob(filepath.Join(*historyDir, "*.json"))
+	for _, path := range paths {
+		game, err := LoadPersistedGame(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Skipping:", err)
+			continue
+		}
+		if _, err := exp.exportGame(game, filter); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not write shard:", err)
+			return 1
+		}
+	}
+
+	if err := exp.flush(); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not write shard:", err)
+		return 1
+	}
+	if err := exp.writeMeta(); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not write metadata:", err)
+		return 1
+	}
+
+	fmt.Printf("Exported %d samples from %d games into %d shards in %s\n", exp.total, len(exp.games), exp.shards, *out)
+	return 0
+}
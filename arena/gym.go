@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"hive-arena/gym"
+)
+
+func runGym(args []string) int {
+	flags := flag.NewFlagSet("gym", flag.ExitOnError)
+	mapList := flags.String("map", "", "comma-separated list of maps (all maps if empty)")
+	mapDir := flags.String("maps", "maps", "directory containing the maps")
+	listen := flags.String("listen", "", "address on which to accept connections, such as 127.0.0.1:8124 (stdio if empty)")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: arena gym [options]")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Runs a reinforcement learning environment driven by JSON lines over stdio,")
+		fmt.Fprintln(os.Stderr, "or over TCP with one environment per connection. See gym/readme.md.")
+		fmt.Fprintln(os.Stderr)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var mapNames []string
+	if *mapList != "" {
+		mapNames = strings.Split(*mapList, ",")
+	}
+	maps, err := loadMaps(*mapDir, mapNames)
+	if err != nil || len(maps) == 0 {
+		fmt.Fprintln(os.Stderr, "Could not load maps:", err)
+		return 2
+	}
+
+	if *listen == "" {
+		if err := gym.NewEnv(maps).Serve(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not listen:", err)
+		return 1
+	}
+	log.Printf("Listening on %s", listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		go func() {
+			defer conn.Close()
+			if err := gym.NewEnv(maps).Serve(conn, conn); err != nil {
+				log.Printf("Connection from %s: %s", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
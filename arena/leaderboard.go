@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	. "hive-arena/common"
+)
+
+func loadSummaries(dir string) []GameSummary {
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+
+	var summaries []GameSummary
+	for _, path := range paths {
+		game, err := LoadPersistedGame(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Skipping:", err)
+			continue
+		}
+		summaries = append(summaries, game.Summary())
+	}
+	return summaries
+}
+
+func runLeaderboard(args []string) int {
+	flags := flag.NewFlagSet("leaderboard", flag.ExitOnError)
+	dir := flags.String("history", "history", "directory containing the history files")
+	asJson := flags.Bool("json", false, "print the leaderboard as JSON, including rating histories")
+	flags.Parse(args)
+
+	board := ComputeRatings(loadSummaries(*dir)).Leaderboard()
+
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(map[string]any{"agents": board})
+		return 0
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(writer, "Rank\tAgent\tRating\tGames\tWin rate\tLast change\t")
+	for i, agent := range board {
+		change := 0.0
+		if n := len(agent.History); n > 1 {
+			change = agent.History[n-1].Rating - agent.History[n-2].Rating
+		} else if n == 1 {
+			change = agent.History[0].Rating - INITIAL_RATING
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%.1f\t%d\t%.0f%%\t%+.1f\t\n", i+1, agent.Name, agent.Rating, agent.Games, agent.WinRate*100, change)
+	}
+	writer.Flush()
+
+	return 0
+}
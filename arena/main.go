@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type Command struct {
+	Name    string
+	Summary string
+	Run     func(args []string) int
+}
+
+var commands = []Command{
+	{"leaderboard", "rate agents from the games in the history directory", runLeaderboard},
+	{"match", "play a game locally between built-in bots and agent commands", runMatch},
+	{"bench", "compare two agents over many games, with confidence intervals", runBench},
+	{"selfplay", "play many games between built-in bots, as fast as possible", runSelfplay},
+	{"gym", "serve a reinforcement learning environment over stdio or TCP", runGym},
+	{"export", "export saved games as a training dataset", runExport},
+	{"scenario", "export a turn of a saved game as a scenario", runScenario},
+}
+
+func usage() {
+	fmt.Println("Usage: arena <command> [options]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, command := range commands {
+		fmt.Printf("  %-12s %s\n", command.Name, command.Summary)
+	}
+	fmt.Println()
+	fmt.Println("Run 'arena <command> -h' for the options of a command.")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, command := range commands {
+		if command.Name == os.Args[1] {
+			os.Exit(command.Run(os.Args[2:]))
+		}
+	}
+
+	usage()
+	os.Exit(2)
+}
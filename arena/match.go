@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"hive-arena/bots"
+	. "hive-arena/common"
+	"hive-arena/luaagent"
+	"hive-arena/match"
+	"hive-arena/runner"
+)
+
+// Creates the agent of a seat: a built-in bot, a WebAssembly module, a Lua
+// script, or a command run as a process speaking JSON lines over stdio
+
+func newSeat(spec string, seed int64, timeout time.Duration) (match.Seat, error) {
+	if slices.Contains(bots.Names(), spec) {
+		bot, err := bots.NewSeeded(spec, seed)
+		return match.Seat{Name: spec, Agent: bot}, err
+	}
+
+	if strings.HasSuffix(spec, ".wasm") {
+		module, err := os.ReadFile(spec)
+		if err != nil {
+			return match.Seat{}, err
+		}
+		process, err := runner.StartWasm(module, os.Stderr, timeout, runner.DefaultWasmMemory, runner.DefaultWasmCalls)
+		return match.Seat{Name: strings.TrimSuffix(filepath.Base(spec), ".wasm"), Agent: process}, err
+	}
+
+	if strings.HasSuffix(spec, ".lua") {
+		agent, err := luaagent.Load(spec, os.Stderr, timeout)
+		if err != nil {
+			return match.Seat{}, err
+		}
+		return match.Seat{Name: filepath.Base(filepath.Dir(spec)), Agent: agent}, nil
+	}
+
+	args := strings.Fields(spec)
+	if len(args) == 0 {
+		return match.Seat{}, fmt.Errorf("empty agent command")
+	}
+
+	process, err := runner.Start(args[0], args[1:], os.Stderr, timeout)
+	if err != nil {
+		return match.Seat{}, err
+	}
+	return match.Seat{Name: filepath.Base(args[0]), Agent: process}, nil
+}
+
+func closeSeats(seats []match.Seat) {
+	for _, seat := range seats {
+		if closer, ok := seat.Agent.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+func loadMap(dir string, name string) (MapData, error) {
+	return LoadMap(filepath.Join(dir, name+".txt"))
+}
+
+func runMatch(args []string) int {
+	flags := flag.NewFlagSet("match", flag.ExitOnError)
+	mapname := flags.String("map", "balanced", "name of the map")
+	mapDir := flags.String("maps", "maps", "directory containing the maps")
+	scenarioPath := flags.String("scenario", "", "scenario file to start the game from, instead of the map")
+	seed := flags.Int64("seed", 0, "seed of the game and of the built-in bots (random if 0)")
+	timeout := flags.Duration("turn-timeout", 2*time.Second, "time an agent command has to answer each turn before being killed")
+	timeBank := flags.Duration("time-bank", 0, "chess clock time budget of each agent for the whole game, replacing the turn timeout if not zero")
+	increment := flags.Duration("increment", 0, "time added to each agent's budget every turn, with a time bank")
+	historyDir := flags.String("history", "history", "directory in which to save the game (not saved if empty)")
+	asJson := flags.Bool("json", false, "print the result as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: arena match [options] <agent> <agent>...")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintf(os.Stderr, "Each agent is either a built-in bot (%s), a WASI module\n", strings.Join(bots.Names(), ", "))
+		fmt.Fprintln(os.Stderr, "ending in .wasm, the main script of a Lua agent ending in .lua, or a command")
+		fmt.Fprintln(os.Stderr, "speaking JSON lines over stdio, such as \"python3 agent.py\".")
+		fmt.Fprintln(os.Stderr)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 || !IsValidNumPlayers(flags.NArg()) {
+		flags.Usage()
+		return 2
+	}
+
+	if *increment > 0 && *timeBank == 0 {
+		fmt.Fprintln(os.Stderr, "The increment requires a time bank")
+		return 2
+	}
+
+	config := match.Config{
+		Map: *mapname,
+		Timing: TurnTiming{
+			TurnTimeout: Duration(*timeout),
+			TimeBank:    Duration(*timeBank),
+			Increment:   Duration(*increment),
+		},
+	}
+
+	// Agents get the whole time bank in chess clock mode, as on the server
+
+	if *timeBank > 0 {
+		*timeout = *timeBank
+	}
+
+	var err error
+	if *scenarioPath != "" {
+		config.Start, config.Scenario, config.Map, err = loadScenario(*scenarioPath, *mapDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not load scenario:", err)
+			return 2
+		}
+	} else if config.MapData, err = loadMap(*mapDir, *mapname); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not load map:", err)
+		return 2
+	}
+
+	if *seed == 0 {
+		*seed = rand.Int63()
+	}
+
+	var seats []match.Seat
+	defer func() { closeSeats(seats) }()
+
+	for i, spec := range flags.Args() {
+		seat, err := newSeat(spec, *seed+int64(i), *timeout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not start agent:", err)
+			return 1
+		}
+		seats = append(seats, seat)
+	}
+
+	config.Seed = *seed
+	result, err := match.Run(config, seats)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not run the game:", err)
+		return 1
+	}
+
+	path := ""
+	if *historyDir != "" {
+		os.MkdirAll(*historyDir, 0755)
+		if path, err = result.Game.Save(*historyDir); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not save the game:", err)
+		}
+	}
+
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(map[string]any{
+			"summary": result.Game.Summary(),
+			"seed":    *seed,
+			"path":    path,
+			"crashes": result.Crashes,
+		})
+	} else {
+		printResult(result, path)
+	}
+
+	if len(result.Crashes) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printResult(result *match.Result, path string) {
+	game := result.Game
+	final := result.Final()
+
+	fmt.Printf("Game %s on %s, seed %d: %s after %d turns\n", game.Id, game.Map, game.Seed, final.EndReason, final.Turn)
+	fmt.Println()
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(writer, "Rank\tPlayer\tAgent\tFlowers\tField flowers\tBees\t")
+	for _, rank := range final.Ranking {
+		fmt.Fprintf(writer, "%d\t%d\t%s\t%d\t%d\t%d\t\n", rank.Rank, rank.Player, game.Players[rank.Player], rank.Flowers, rank.FieldFlowers, rank.Bees)
+	}
+	writer.Flush()
+
+	// Agents that keep failing are only reported once
+
+	reported := make(map[int]bool)
+	for _, crash := range result.Crashes {
+		if !reported[crash.Player] {
+			reported[crash.Player] = true
+			fmt.Printf("\nAgent %s (player %d) failed at turn %d: %s\n", game.Players[crash.Player], crash.Player, crash.Turn, crash.Error)
+		}
+	}
+
+	if path != "" {
+		fmt.Println()
+		fmt.Println("Saved to", path)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A NumPy array, written in the .npy format version 1.0
+
+type npyArray struct {
+	name  string
+	dtype string
+	shape []int
+	data  any
+}
+
+func (array npyArray) write(w io.Writer) error {
+	var dims []string
+	for _, dim := range array.shape {
+		dims = append(dims, fmt.Sprint(dim))
+	}
+	shape := strings.Join(dims, ", ")
+	if len(dims) == 1 {
+		shape += ","
+	}
+
+	// The header is padded with spaces so that the data starts on a multiple
+	// of 64 bytes, and ends with a newline
+
+	header := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%s), }", array.dtype, shape)
+	padding := 64 - (10+len(header)+1)%64
+	header += strings.Repeat(" ", padding%64) + "\n"
+
+	if _, err := io.WriteString(w, "\x93NUMPY\x01\x00"); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint16(len(header))); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, array.data)
+}
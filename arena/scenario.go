@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	. "hive-arena/common"
+)
+
+// Loads a scenario file, along with the map it refers to, if any. Games started
+// from it are named after the file, and after the map when there is one.
+
+func loadScenario(path string, mapDir string) (state *GameState, name string, mapname string, err error) {
+	scenario, err := LoadScenario(path)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	name = strings.TrimSuffix(filepath.Base(path), ".json")
+	mapname = name
+
+	maps := make(map[string]MapData)
+	if scenario.Map != "" {
+		if maps, err = loadMaps(mapDir, []string{scenario.Map}); err != nil {
+			return nil, "", "", err
+		}
+		mapname = scenario.Map
+	}
+
+	state, err = scenario.State(maps)
+	return state, name, mapname, err
+}
+
+func runScenario(args []string) int {
+	flags := flag.NewFlagSet("scenario", flag.ExitOnError)
+	turn := flags.Int("turn", -1, "turn to export (the last one if negative)")
+	out := flags.String("out", "-", "file in which to write the scenario (- for stdout)")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: arena scenario [options] <history file>")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "Exports the state of a saved game on one of its turns as a scenario, which")
+		fmt.Fprintln(os.Stderr, "'arena match -scenario' and the server can start new games from.")
+		fmt.Fprintln(os.Stderr)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	game, err := LoadPersistedGame(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not load game:", err)
+		return 1
+	}
+	if len(game.History) == 0 {
+		fmt.Fprintln(os.Stderr, "Could not load game: no turns recorded")
+		return 1
+	}
+
+	state := game.History[len(game.History)-1].State
+	if *turn >= 0 {
+		index := slices.IndexFunc(game.History, func(entry Turn) bool { return entry.State.Turn == uint(*turn) })
+		if index < 0 {
+			fmt.Fprintf(os.Stderr, "Invalid turn: %d, the game goes from turn %d to %d\n", *turn, game.History[0].State.Turn, state.Turn)
+			return 1
+		}
+		state = game.History[index].State
+	}
+
+	data, err := json.MarshalIndent(ScenarioOf(state), "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not encode scenario:", err)
+		return 1
+	}
+	data = append(data, '\n')
+
+	if *out == "-" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not write scenario:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"hive-arena/bots"
+	. "hive-arena/common"
+	"hive-arena/selfplay"
+)
+
+func loadMaps(dir string, names []string) (map[string]MapData, error) {
+	if len(names) == 0 {
+		paths, _ := filepath.Glob(filepath.Join(dir, "*.txt"))
+		for _, path := range paths {
+			names = append(names, strings.TrimSuffix(filepath.Base(path), ".txt"))
+		}
+	}
+
+	maps := make(map[string]MapData)
+	for _, name := range names {
+		mapdata, err := loadMap(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		maps[name] = mapdata
+	}
+	return maps, nil
+}
+
+func runSelfplay(args []string) int {
+	flags := flag.NewFlagSet("selfplay", flag.ExitOnError)
+	games := flags.Int("games", 100, "number of games")
+	workers := flags.Int("workers", runtime.NumCPU(), "number of games played at the same time")
+	mapList := flags.String("map", "", "comma-separated list of maps (all maps if empty)")
+	mapDir := flags.String("maps", "maps", "directory containing the maps")
+	seed := flags.Int64("seed", 1, "seed of the first game, incremented for each game")
+	rotate := flags.Bool("rotate", false, "shift the bots by one seat from one game to the next")
+	out := flags.String("out", "", "file in which to write the result of each game as a JSON line (- for stdout)")
+	trace := flags.Bool("trace", false, "include the orders of every turn in the results")
+	historyDir := flags.String("history", "", "directory in which to save every game for the viewer (slower)")
+	cpuProfile := flags.String("cpuprofile", "", "file in which to write a CPU profile")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: arena selfplay [options] <bot> <bot>...")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintf(os.Stderr, "Plays many games between built-in bots (%s) as fast as possible,\n", strings.Join(bots.Names(), ", "))
+		fmt.Fprintln(os.Stderr, "and reports the throughput and the wins of each bot.")
+		fmt.Fprintln(os.Stderr)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var mapNames []string
+	if *mapList != "" {
+		mapNames = strings.Split(*mapList, ",")
+	}
+	maps, err := loadMaps(*mapDir, mapNames)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not load map:", err)
+		return 2
+	}
+
+	var output io.Writer
+	switch *out {
+	case "":
+	case "-":
+		output = os.Stdout
+	default:
+		file, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not create output file:", err)
+			return 1
+		}
+		defer file.Close()
+		output = file
+	}
+
+	if *historyDir != "" {
+		os.MkdirAll(*historyDir, 0755)
+	}
+
+	if *cpuProfile != "" {
+		file, err := os.Create(*cpuProfile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not create profile:", err)
+			return 1
+		}
+		defer file.Close()
+		pprof.StartCPUProfile(file)
+		defer pprof.StopCPUProfile()
+	}
+
+	config := selfplay.Config{
+		Maps:    maps,
+		Bots:    flags.Args(),
+		Games:   *games,
+		Workers: *workers,
+		Seed:    *seed,
+		Rotate:  *rotate,
+		History: *historyDir != "",
+		Trace:   *trace,
+	}
+
+	start := time.Now()
+	results, err := selfplay.Run(context.Background(), config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flags.Usage()
+		return 2
+	}
+
+	var encoder *json.Encoder
+	if output != nil {
+		encoder = json.NewEncoder(output)
+	}
+
+	var turns uint
+	var gameTime time.Duration
+	crashes := 0
+	wins := make(map[string]float64)
+	played := make(map[string]int)
+
+	for result := range results {
+		turns += result.Turns
+		gameTime += time.Duration(result.Duration)
+		crashes += len(result.Crashes)
+
+		// Shared first places count as a fraction of a win
+
+		var winners []int
+		for _, rank := range result.Ranking {
+			if rank.Rank == 1 {
+				winners = append(winners, rank.Player)
+			}
+		}
+		for player, name := range result.Players {
+			played[name]++
+			for _, winner := range winners {
+				if winner == player {
+					wins[name] += 1 / float64(len(winners))
+				}
+			}
+		}
+
+		if encoder != nil {
+			encoder.Encode(result)
+		}
+		if result.Game != nil {
+			if _, err := result.Game.Save(*historyDir); err != nil {
+				fmt.Fprintln(os.Stderr, "Could not save the game:", err)
+			}
+		}
+	}
+
+	elapsed := time.Since(start)
+
+	// The report goes to stderr when the results go to stdout
+
+	report := io.Writer(os.Stdout)
+	if output == os.Stdout {
+		report = os.Stderr
+	}
+
+	fmt.Fprintf(report, "%d games, %d turns in %s with %d workers\n", *games, turns, elapsed.Round(time.Millisecond), *workers)
+	fmt.Fprintf(report, "%.0f games/min, %.0f turns/s, %s per game on average\n",
+		float64(*games)/elapsed.Minutes(), float64(turns)/elapsed.Seconds(),
+		(gameTime / time.Duration(max(*games, 1))).Round(time.Microsecond))
+	fmt.Fprintln(report)
+
+	writer := tabwriter.NewWriter(report, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(writer, "Bot\tSeats\tWins\tWin rate\t")
+	for _, name := range uniq(config.Bots) {
+		fmt.Fprintf(writer, "%s\t%d\t%.1f\t%.0f%%\t\n", name, played[name], wins[name], 100*wins[name]/float64(played[name]))
+	}
+	writer.Flush()
+
+	if crashes > 0 {
+		fmt.Fprintf(os.Stderr, "Bots failed %d times\n", crashes)
+		return 1
+	}
+	return 0
+}
+
+func uniq(names []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	return result
+}
@@ -0,0 +1,74 @@
+package bots
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"math/rand"
+	"slices"
+
+	. "hive-arena/common"
+)
+
+// Built-in agents, by name. Each call creates a fresh agent for one seat, with
+// its own source of randomness.
+
+var constructors = map[string]func(rng *rand.Rand) Agent{
+	"random": func(rng *rand.Rand) Agent { return &Random{rng} },
+	"greedy": func(rng *rand.Rand) Agent { return &Greedy{rng} },
+
+	"forager":   func(rng *rand.Rand) Agent { return &Forager{} },
+	"raider":    func(rng *rand.Rand) Agent { return &Raider{} },
+	"fortifier": func(rng *rand.Rand) Agent { return &Fortifier{} },
+}
+
+func New(name string) (Agent, error) {
+	return NewSeeded(name, rand.Int63())
+}
+
+// A bot whose moves only depend on the seed and the views it receives
+
+func NewSeeded(name string, seed int64) (Agent, error) {
+	constructor, found := constructors[name]
+	if !found {
+		return nil, fmt.Errorf("unknown bot: %s", name)
+	}
+	return constructor(rand.New(rand.NewSource(seed))), nil
+}
+
+func Names() []string {
+	return slices.Sorted(maps.Keys(constructors))
+}
+
+func compareCoords(a, b Coords) int {
+	return cmp.Or(cmp.Compare(a.Row, b.Row), cmp.Compare(a.Col, b.Col))
+}
+
+// Coordinates of a player's entities of one type, in a stable order
+
+func units(view *GameState, player int, kind EntityType) []Coords {
+	var coords []Coords
+	for c, hex := range view.Hexes {
+		if hex.Entity != nil && hex.Entity.Type == kind && hex.Entity.Player == player {
+			coords = append(coords, c)
+		}
+	}
+	slices.SortFunc(coords, compareCoords)
+	return coords
+}
+
+// Whether a hex can be entered, as far as the view tells
+
+func isFree(view *GameState, coords Coords) bool {
+	hex := view.Hexes[coords]
+	return hex != nil && hex.Terrain.IsWalkable() && hex.Entity == nil
+}
+
+func nearest(from Coords, targets []Coords) (Coords, bool) {
+	if len(targets) == 0 {
+		return Coords{}, false
+	}
+	return slices.MinFunc(targets, func(a, b Coords) int {
+		return cmp.Or(cmp.Compare(from.Distance(a), from.Distance(b)), compareCoords(a, b))
+	}), true
+}
@@ -0,0 +1,194 @@
+package bots
+
+import (
+	"cmp"
+	"slices"
+
+	. "hive-arena/common"
+)
+
+// How many unexplored destinations a bee tries before giving up for the turn
+
+const MAX_EXPLORE_TARGETS = 3
+
+const MAX_BEES = 12
+
+// A greedy forager. Bees walk the shortest known path to the nearest field
+// that has flowers and that no other bee is heading for, forage it, then walk
+// back to a hive to deliver. Bees with nothing to do explore the map. Hives
+// spawn bees whenever the player can afford them, up to MAX_BEES, since
+// flowers left at the end of the game decide the ranking.
+//
+// The forager also provides the turn bookkeeping of the other reference bots:
+// the orders given so far, the flowers left to spend, and the hexes that own
+// bees will move into or are heading for this turn.
+
+type Forager struct {
+	memory Memory
+
+	view      *GameState
+	player    int
+	orders    []*Order
+	resources uint
+	claimed   map[Coords]bool
+	targeted  map[Coords]bool
+	frontier  []Coords
+}
+
+func (bot *Forager) Think(view *GameState, player int) ([]*Order, error) {
+	bot.begin(view, player)
+
+	for _, coords := range units(view, player, BEE) {
+		bot.forage(coords)
+	}
+	bot.spawn()
+
+	return bot.orders, nil
+}
+
+func (bot *Forager) begin(view *GameState, player int) {
+	bot.memory.update(view)
+
+	bot.view = view
+	bot.player = player
+	bot.orders = nil
+	bot.resources = view.PlayerResources[0]
+	bot.claimed = make(map[Coords]bool)
+	bot.targeted = make(map[Coords]bool)
+	bot.frontier = nil
+}
+
+func (bot *Forager) order(kind OrderType, coords Coords, dir Direction) {
+	bot.orders = append(bot.orders, &Order{Type: kind, Coords: coords, Direction: dir})
+}
+
+// Hexes taken by a visible entity, or by an own bee moving there this turn
+
+func (bot *Forager) isBlocked(coords Coords) bool {
+	if bot.claimed[coords] {
+		return true
+	}
+	hex := bot.view.Hexes[coords]
+	return hex != nil && hex.Entity != nil
+}
+
+// Moves a bee one step along the shortest path to the nearest goal, and
+// returns the goal, or false if no goal can be reached
+
+func (bot *Forager) moveTo(from Coords, goal func(Coords) bool) (Coords, bool) {
+	dir, target, _, found := bot.memory.path(from, goal, bot.isBlocked)
+	if !found {
+		return Coords{}, false
+	}
+
+	bot.order(MOVE, from, dir)
+	bot.claimed[from.Neighbour(dir)] = true
+	return target, true
+}
+
+func (bot *Forager) isNextToHive(coords Coords) bool {
+	for _, n := range coords.Neighbours() {
+		hex := bot.view.Hexes[n]
+		if hex != nil && hex.Entity != nil && hex.Entity.Type == HIVE && hex.Entity.Player == bot.player {
+			return true
+		}
+	}
+	return false
+}
+
+func (bot *Forager) forage(bee Coords) {
+	hex := bot.view.Hexes[bee]
+
+	if hex.Entity.HasFlower {
+		if bot.isNextToHive(bee) {
+			bot.order(FORAGE, bee, "")
+		} else {
+			bot.moveTo(bee, bot.isNextToHive)
+		}
+		return
+	}
+
+	if hex.Terrain == FIELD && hex.Resources > 0 {
+		bot.order(FORAGE, bee, "")
+		return
+	}
+
+	// A search that finds no field has gone through every reachable hex,
+	// which exploring then picks from
+
+	reachable, field, found := bot.memory.search(bee, func(c Coords) bool {
+		return bot.memory.Resources[c] > 0 && !bot.targeted[c]
+	}, bot.isBlocked)
+	if found {
+		dir := reachable[field].first
+		bot.order(MOVE, bee, dir)
+		bot.claimed[bee.Neighbour(dir)] = true
+		bot.targeted[field] = true
+		return
+	}
+
+	delete(reachable, bee)
+	bot.explore(bee, reachable)
+}
+
+// Bees head for the edge of the known map farthest from their hives, each for
+// a different hex, so that they keep going in the same direction as they see
+// more of the map. reachable holds the paths to all the hexes the bee can
+// reach.
+
+func (bot *Forager) explore(bee Coords, reachable map[Coords]step) {
+	hives := units(bot.view, bot.player, HIVE)
+	distance := func(c Coords) int {
+		if hive, found := nearest(c, hives); found {
+			return c.Distance(hive)
+		}
+		return c.Distance(bee)
+	}
+
+	// The known map does not change during a turn
+
+	if bot.frontier == nil {
+		bot.frontier = []Coords{}
+		for coords, terrain := range bot.memory.Terrain {
+			if terrain.IsWalkable() && bot.memory.isFrontier(coords) {
+				bot.frontier = append(bot.frontier, coords)
+			}
+		}
+	}
+
+	frontier := slices.DeleteFunc(slices.Clone(bot.frontier), func(c Coords) bool {
+		return bot.targeted[c]
+	})
+	slices.SortFunc(frontier, func(a, b Coords) int {
+		return cmp.Or(cmp.Compare(distance(b), distance(a)), compareCoords(a, b))
+	})
+
+	for _, target := range frontier[:min(len(frontier), MAX_EXPLORE_TARGETS)] {
+		if step, found := reachable[target]; found {
+			bot.order(MOVE, bee, step.first)
+			bot.claimed[bee.Neighbour(step.first)] = true
+			bot.targeted[target] = true
+			return
+		}
+	}
+}
+
+func (bot *Forager) spawn() {
+	bees := len(units(bot.view, bot.player, BEE))
+
+	for _, hive := range units(bot.view, bot.player, HIVE) {
+		for _, dir := range Directions {
+			target := hive.Neighbour(dir)
+			if bot.resources < BEE_COST || bees >= MAX_BEES {
+				return
+			}
+			if isFree(bot.view, target) && !bot.claimed[target] {
+				bot.order(SPAWN, hive, dir)
+				bot.claimed[target] = true
+				bot.resources -= BEE_COST
+				bees++
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package bots
+
+import (
+	. "hive-arena/common"
+)
+
+// Fortifiers only build walls once they have enough bees to forage
+
+const FORTIFIER_MIN_BEES = 4
+
+// A fortifier walls off its fields. Fields within FIELD_OF_VIEW of its hives,
+// which count towards its score on ties, are its own. Bees next to an empty
+// hex that borders one of those fields, on the far side from the nearest hive,
+// build a wall there. Walls are never built next to a hive, so that bees can
+// always deliver. Otherwise the fortifier forages like the forager.
+
+type Fortifier struct {
+	Forager
+}
+
+func (bot *Fortifier) Think(view *GameState, player int) ([]*Order, error) {
+	bot.begin(view, player)
+
+	bees := units(view, player, BEE)
+	hives := units(view, player, HIVE)
+
+	for _, coords := range bees {
+		if view.Hexes[coords].Entity.HasFlower || len(bees) < FORTIFIER_MIN_BEES || !bot.fortify(coords, hives) {
+			bot.forage(coords)
+		}
+	}
+	bot.spawn()
+
+	return bot.orders, nil
+}
+
+func (bot *Fortifier) isWallSpot(coords Coords, hives []Coords) bool {
+	if bot.memory.Terrain[coords] != EMPTY || !isFree(bot.view, coords) || bot.claimed[coords] || bot.isNextToHive(coords) {
+		return false
+	}
+
+	hive, found := nearest(coords, hives)
+	if !found {
+		return false
+	}
+
+	for _, field := range coords.Neighbours() {
+		if bot.memory.Terrain[field] == FIELD &&
+			field.Distance(hive) <= FIELD_OF_VIEW &&
+			field.Distance(hive) < coords.Distance(hive) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns false if the bee cannot build a wall
+
+func (bot *Fortifier) fortify(bee Coords, hives []Coords) bool {
+	if bot.resources < WALL_COST {
+		return false
+	}
+
+	for _, dir := range Directions {
+		target := bee.Neighbour(dir)
+		if bot.isWallSpot(target, hives) {
+			bot.order(BUILD_WALL, bee, dir)
+			bot.claimed[target] = true
+			bot.resources -= WALL_COST
+			return true
+		}
+	}
+	return false
+}
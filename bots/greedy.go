@@ -0,0 +1,92 @@
+package bots
+
+import (
+	"math/rand"
+
+	. "hive-arena/common"
+)
+
+// Forages the nearest visible field, walks straight back to the nearest hive,
+// and spawns bees whenever it can afford them. Bees only look one step ahead,
+// so they can get stuck behind rocks and walls.
+
+type Greedy struct {
+	rng *rand.Rand
+}
+
+func (bot *Greedy) Think(view *GameState, player int) ([]*Order, error) {
+	var orders []*Order
+
+	hives := units(view, player, HIVE)
+
+	var fields []Coords
+	for coords, hex := range view.Hexes {
+		if hex.Terrain == FIELD && hex.Resources > 0 {
+			fields = append(fields, coords)
+		}
+	}
+
+	// Hexes that our own bees will move into this turn
+
+	claimed := make(map[Coords]bool)
+
+	step := func(from Coords, target Coords) *Order {
+		var best *Order
+		for _, dir := range Directions {
+			next := from.Neighbour(dir)
+			if !isFree(view, next) || claimed[next] {
+				continue
+			}
+			if best == nil || next.Distance(target) < best.Target().Distance(target) {
+				best = &Order{Type: MOVE, Coords: from, Direction: dir}
+			}
+		}
+		if best != nil {
+			claimed[best.Target()] = true
+		}
+		return best
+	}
+
+	for _, coords := range units(view, player, BEE) {
+		bee := view.Hexes[coords]
+		var order *Order
+
+		if bee.Entity.HasFlower {
+			hive, found := nearest(coords, hives)
+			if found && coords.Distance(hive) == 1 {
+				order = &Order{Type: FORAGE, Coords: coords}
+			} else if found {
+				order = step(coords, hive)
+			}
+		} else if bee.Terrain == FIELD && bee.Resources > 0 {
+			order = &Order{Type: FORAGE, Coords: coords}
+		} else if field, found := nearest(coords, fields); found {
+			order = step(coords, field)
+		} else {
+			order = step(coords, coords.Neighbour(Directions[bot.rng.Intn(len(Directions))]))
+		}
+
+		if order != nil {
+			orders = append(orders, order)
+		}
+	}
+
+	// Player views only hold the player's own resources
+
+	resources := view.PlayerResources[0]
+	for _, hive := range hives {
+		if resources < BEE_COST {
+			break
+		}
+		for _, dir := range Directions {
+			if target := hive.Neighbour(dir); isFree(view, target) && !claimed[target] {
+				orders = append(orders, &Order{Type: SPAWN, Coords: hive, Direction: dir})
+				claimed[target] = true
+				resources -= BEE_COST
+				break
+			}
+		}
+	}
+
+	return orders, nil
+}
@@ -0,0 +1,104 @@
+package bots
+
+import (
+	. "hive-arena/common"
+)
+
+// What a bot remembers of the map. Terrain never changes, so hexes seen once
+// stay known, while flowers are only updated when their field is in view.
+
+type Memory struct {
+	Terrain   map[Coords]Terrain
+	Resources map[Coords]uint
+
+	// Buffers reused by every search, as bots search many times a turn
+
+	visited map[Coords]step
+	queue   []Coords
+}
+
+func (memory *Memory) update(view *GameState) {
+	if memory.Terrain == nil {
+		memory.Terrain = make(map[Coords]Terrain)
+		memory.Resources = make(map[Coords]uint)
+	}
+
+	for coords, hex := range view.Hexes {
+		memory.Terrain[coords] = hex.Terrain
+		if hex.Terrain == FIELD {
+			memory.Resources[coords] = hex.Resources
+		}
+	}
+}
+
+// Whether a known hex has neighbours that were never seen
+
+func (memory *Memory) isFrontier(coords Coords) bool {
+	for _, n := range coords.Neighbours() {
+		if _, known := memory.Terrain[n]; !known {
+			return true
+		}
+	}
+	return false
+}
+
+type step struct {
+	first    Direction
+	distance int
+}
+
+// Breadth-first search from a hex, through known walkable hexes that are not
+// blocked, until a hex for which goal is true. Returns the first step and the
+// length of the shortest path to each hex visited, which is only valid until
+// the next search.
+
+func (memory *Memory) search(from Coords, goal func(Coords) bool, blocked func(Coords) bool) (map[Coords]step, Coords, bool) {
+	if memory.visited == nil {
+		memory.visited = make(map[Coords]step, len(memory.Terrain))
+	}
+	clear(memory.visited)
+
+	visited := memory.visited
+	visited[from] = step{}
+	queue := append(memory.queue[:0], from)
+	defer func() { memory.queue = queue }()
+
+	for i := 0; i < len(queue); i++ {
+		current := queue[i]
+		here := visited[current]
+
+		for _, dir := range Directions {
+			next := current.Neighbour(dir)
+			if _, seen := visited[next]; seen {
+				continue
+			}
+			if !memory.Terrain[next].IsWalkable() || blocked(next) {
+				continue
+			}
+
+			step := step{here.first, here.distance + 1}
+			if current == from {
+				step.first = dir
+			}
+			visited[next] = step
+
+			if goal != nil && goal(next) {
+				return visited, next, true
+			}
+			queue = append(queue, next)
+		}
+	}
+
+	return visited, Coords{}, false
+}
+
+// Path to the nearest hex for which goal is true. Returns the direction of the
+// first step, the hex reached, and the length of the path.
+
+func (memory *Memory) path(from Coords, goal func(Coords) bool, blocked func(Coords) bool) (Direction, Coords, int, bool) {
+	visited, reached, found := memory.search(from, goal, blocked)
+	if !found {
+		return "", Coords{}, 0, false
+	}
+	return visited[reached].first, reached, visited[reached].distance, true
+}
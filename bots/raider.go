@@ -0,0 +1,86 @@
+package bots
+
+import (
+	. "hive-arena/common"
+)
+
+// How far raiders go to hunt a bee
+
+const RAID_RANGE = 8
+
+// A raider hunts enemy bees carrying flowers. Up to half of its bees, among
+// those not carrying a flower, attack any such bee next to them, hoping to
+// stun it before it delivers, or walk towards the nearest one within
+// RAID_RANGE steps. The other bees forage like the forager.
+
+type Raider struct {
+	Forager
+
+	// Whether any prey is in view this turn, as searching for none is costly
+
+	hunting bool
+}
+
+func (bot *Raider) Think(view *GameState, player int) ([]*Order, error) {
+	bot.begin(view, player)
+
+	bot.hunting = false
+	for coords := range view.Hexes {
+		bot.hunting = bot.hunting || bot.isPrey(coords)
+	}
+
+	bees := units(view, player, BEE)
+	raiders := 0
+
+	for _, coords := range bees {
+		if !view.Hexes[coords].Entity.HasFlower && raiders < len(bees)/2 && bot.raid(coords) {
+			raiders++
+		} else {
+			bot.forage(coords)
+		}
+	}
+	bot.spawn()
+
+	return bot.orders, nil
+}
+
+func (bot *Raider) isPrey(coords Coords) bool {
+	hex := bot.view.Hexes[coords]
+	return hex != nil && hex.Entity != nil &&
+		hex.Entity.Type == BEE &&
+		hex.Entity.Player != bot.player &&
+		hex.Entity.HasFlower
+}
+
+func (bot *Raider) isNextToPrey(coords Coords) bool {
+	for _, n := range coords.Neighbours() {
+		if bot.isPrey(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns false if no prey is in range
+
+func (bot *Raider) raid(bee Coords) bool {
+	for _, dir := range Directions {
+		if bot.isPrey(bee.Neighbour(dir)) {
+			bot.order(ATTACK, bee, dir)
+			return true
+		}
+	}
+
+	if !bot.hunting {
+		return false
+	}
+
+	dir, _, distance, found := bot.memory.path(bee, bot.isNextToPrey, bot.isBlocked)
+	if !found || distance > RAID_RANGE {
+		return false
+	}
+
+	bot.order(MOVE, bee, dir)
+	bot.claimed[bee.Neighbour(dir)] = true
+	return true
+}
@@ -0,0 +1,27 @@
+package bots
+
+import (
+	"math/rand"
+
+	. "hive-arena/common"
+)
+
+// Moves every bee in a random direction, like the example agent
+
+type Random struct {
+	rng *rand.Rand
+}
+
+func (bot *Random) Think(view *GameState, player int) ([]*Order, error) {
+	var orders []*Order
+
+	for _, coords := range units(view, player, BEE) {
+		orders = append(orders, &Order{
+			Type:      MOVE,
+			Coords:    coords,
+			Direction: Directions[bot.rng.Intn(len(Directions))],
+		})
+	}
+
+	return orders, nil
+}
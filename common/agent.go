@@ -0,0 +1,10 @@
+package common
+
+// An agent that plays in the same process as the game, such as the built-in
+// bots of the server. Each turn, it receives the view of its player, as sent
+// to remote agents by the /game route, and returns the orders of that player.
+// An agent instance plays a single seat, and may keep state between turns.
+
+type Agent interface {
+	Think(view *GameState, player int) ([]*Order, error)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/rand"
 	"slices"
+	"time"
 )
 
 const (
@@ -43,6 +44,10 @@ type Order struct {
 	Coords    Coords      `json:"coords"`
 	Direction Direction   `json:"direction"`
 	Status    OrderStatus `json:"status"`
+
+	// The entity destroyed or stunned by a successful attack
+
+	Hit *Entity `json:"hit,omitempty"`
 }
 
 type OrderType string
@@ -81,17 +86,45 @@ func (o *Order) Target() Coords {
 	return o.Coords.Neighbour(o.Direction)
 }
 
+type EndReason string
+
+const (
+	ALL_FIELDS_DEPLETED EndReason = "ALL_FIELDS_DEPLETED"
+	NO_DELIVERIES       EndReason = "NO_DELIVERIES"
+	ABORTED             EndReason = "ABORTED"
+)
+
+type TurnTiming struct {
+	MinTurnDuration Duration `json:"minTurnDuration"`
+	TurnTimeout     Duration `json:"turnTimeout"`
+
+	// Chess clock mode, replacing the turn timeout when the time bank is not zero
+
+	TimeBank  Duration `json:"timeBank,omitzero"`
+	Increment Duration `json:"increment,omitzero"`
+}
+
+// The time given each turn to a player whose time bank is empty
+
+const EMPTY_BANK_WINDOW = 100 * time.Millisecond
+
 type GameState struct {
 	NumPlayers         int             `json:"numPlayers"`
 	Turn               uint            `json:"turn"`
 	Hexes              map[Coords]*Hex `json:"hexes"`
 	PlayerResources    []uint          `json:"playerResources"`
 	LastResourceChange uint            `json:"lastResourceChange"`
+	Timing             TurnTiming      `json:"timing"`
+	Clocks             []Duration      `json:"clocks,omitempty"`
+	Forfeits           []int           `json:"forfeits,omitempty"`
 
-	Winners  []int `json:"winners,omitempty"`
-	GameOver bool  `json:"gameOver"`
+	Winners   []int     `json:"winners,omitempty"`
+	Ranking   []Rank    `json:"ranking,omitempty"`
+	GameOver  bool      `json:"gameOver"`
+	EndReason EndReason `json:"endReason,omitempty"`
 
 	stunned map[*Entity]bool
+	rng     *rand.Rand
 }
 
 var playerMappings = [][]int{
@@ -149,6 +182,28 @@ func NewGameState(mapData MapData, numPlayers int) *GameState {
 	return gs
 }
 
+// Makes the outcome of the following turns depend only on the seed and the
+// orders played. Clones are not seeded.
+
+func (gs *GameState) SetSeed(seed int64) {
+	gs.rng = rand.New(rand.NewSource(seed))
+}
+
+func (gs *GameState) float64() float64 {
+	if gs.rng == nil {
+		return rand.Float64()
+	}
+	return gs.rng.Float64()
+}
+
+func (gs *GameState) shuffle(n int, swap func(i, j int)) {
+	if gs.rng == nil {
+		rand.Shuffle(n, swap)
+		return
+	}
+	gs.rng.Shuffle(n, swap)
+}
+
 func (gs *GameState) EntityAt(coords Coords) *Entity {
 	hex, ok := gs.Hexes[coords]
 	if !ok {
@@ -162,11 +217,17 @@ func (gs *GameState) ProcessOrders(orders [][]*Order) ([]*Order, error) {
 		return nil, fmt.Errorf("cannot process orders in a finished game")
 	}
 
-	// Fill in player ids
+	// Fill in player ids, and drop orders from players who forfeited
 
+	orders = slices.Clone(orders)
 	for player, playerOrders := range orders {
+		if gs.HasForfeited(player) {
+			orders[player] = nil
+			continue
+		}
 		for _, order := range playerOrders {
 			order.Player = player
+			order.Hit = nil
 		}
 	}
 
@@ -198,7 +259,7 @@ func (gs *GameState) ProcessOrders(orders [][]*Order) ([]*Order, error) {
 
 		// Shuffle them
 
-		rand.Shuffle(len(roundOrders), func(i, j int) {
+		gs.shuffle(len(roundOrders), func(i, j int) {
 			roundOrders[i], roundOrders[j] = roundOrders[j], roundOrders[i]
 		})
 
@@ -300,12 +361,16 @@ func (gs *GameState) applyAttackOrder(order *Order) {
 		return
 	}
 
-	if entity.Type == WALL && rand.Float64() < WALL_ATTACK_CHANCE {
+	if entity.Type == WALL && gs.float64() < WALL_ATTACK_CHANCE {
 		gs.Hexes[order.Target()].Entity = nil
+		hit := *entity
+		order.Hit = &hit
 	}
 
-	if entity.Type == BEE && rand.Float64() < STUN_CHANCE {
+	if entity.Type == BEE && gs.float64() < STUN_CHANCE {
 		gs.stunned[entity] = true
+		hit := *entity
+		order.Hit = &hit
 	}
 
 	order.Status = OK
@@ -396,6 +461,25 @@ func (gs *GameState) applySpawnOrder(order *Order) {
 	order.Status = OK
 }
 
+func (gs *GameState) HasForfeited(player int) bool {
+	return slices.Contains(gs.Forfeits, player)
+}
+
+// A player who forfeits keeps their entities on the map, but cannot act or win anymore
+
+func (gs *GameState) Forfeit(player int) {
+	if player < 0 || player >= gs.NumPlayers || gs.HasForfeited(player) {
+		return
+	}
+	gs.Forfeits = append(gs.Forfeits, player)
+}
+
+func (gs *GameState) Abort() {
+	gs.GameOver = true
+	gs.EndReason = ABORTED
+	gs.Ranking = gs.Ranks()
+}
+
 func (gs *GameState) checkEndGame() {
 
 	// No resources left
@@ -410,51 +494,81 @@ func (gs *GameState) checkEndGame() {
 
 	if resourcesLeft == 0 {
 		gs.GameOver = true
+		gs.EndReason = ALL_FIELDS_DEPLETED
 	}
 
 	// No influence change in a while
 
-	if gs.Turn-gs.LastResourceChange > RESOURCE_TIMEOUT {
+	if !gs.GameOver && gs.Turn-gs.LastResourceChange > RESOURCE_TIMEOUT {
 		gs.GameOver = true
+		gs.EndReason = NO_DELIVERIES
 	}
 
 	// Determine winners
 
 	if gs.GameOver {
-		maxResources := slices.Max(gs.PlayerResources)
+		var maxResources uint
 		for player, resources := range gs.PlayerResources {
-			if resources == maxResources {
+			if !gs.HasForfeited(player) {
+				maxResources = max(maxResources, resources)
+			}
+		}
+		for player, resources := range gs.PlayerResources {
+			if resources == maxResources && !gs.HasForfeited(player) {
 				gs.Winners = append(gs.Winners, player)
 			}
 		}
+
+		gs.Ranking = gs.Ranks()
 	}
 }
 
-func (gs *GameState) isVisibleBy(coords Coords, player int) bool {
-	for hcoords, hex := range gs.Hexes {
-		if hex.Entity != nil &&
-			hex.Entity.Player == player &&
-			hcoords.Distance(coords) <= FIELD_OF_VIEW {
-			return true
+// Offsets of all hexes within the field of view of an entity
+
+var fieldOfView = func() []Coords {
+	var offsets []Coords
+	for row := -FIELD_OF_VIEW; row <= FIELD_OF_VIEW; row++ {
+		for col := -2 * FIELD_OF_VIEW; col <= 2*FIELD_OF_VIEW; col++ {
+			offset := Coords{row, col}
+			if (row+col)%2 == 0 && offset.Distance(Coords{}) <= FIELD_OF_VIEW {
+				offsets = append(offsets, offset)
+			}
+		}
+	}
+	return offsets
+}()
+
+// Hexes within the field of view of any of the player's entities
+
+func (gs *GameState) visibleBy(player int) map[Coords]*Hex {
+	visible := make(map[Coords]*Hex)
+	for coords, hex := range gs.Hexes {
+		if hex.Entity == nil || hex.Entity.Player != player {
+			continue
+		}
+		for _, offset := range fieldOfView {
+			seen := Coords{coords.Row + offset.Row, coords.Col + offset.Col}
+			if hex, found := gs.Hexes[seen]; found {
+				visible[seen] = hex
+			}
 		}
 	}
-	return false
+	return visible
 }
 
 func (gs *GameState) PlayerView(player int) *GameState {
 	view := &GameState{
 		NumPlayers:         gs.NumPlayers,
 		Turn:               gs.Turn,
-		Hexes:              make(map[Coords]*Hex),
+		Hexes:              gs.visibleBy(player),
 		LastResourceChange: gs.LastResourceChange,
+		Timing:             gs.Timing,
+		Clocks:             slices.Clone(gs.Clocks),
+		Forfeits:           slices.Clone(gs.Forfeits),
 		Winners:            gs.Winners,
+		Ranking:            gs.Ranking,
 		GameOver:           gs.GameOver,
-	}
-
-	for coords, hex := range gs.Hexes {
-		if gs.isVisibleBy(coords, player) {
-			view.Hexes[coords] = hex
-		}
+		EndReason:          gs.EndReason,
 	}
 
 	view.PlayerResources = []uint{gs.PlayerResources[player]}
@@ -481,7 +595,12 @@ func (gs *GameState) Clone() *GameState {
 		Hexes:              hexes,
 		PlayerResources:    slices.Clone(gs.PlayerResources),
 		LastResourceChange: gs.LastResourceChange,
+		Timing:             gs.Timing,
+		Clocks:             slices.Clone(gs.Clocks),
+		Forfeits:           slices.Clone(gs.Forfeits),
 		Winners:            slices.Clone(gs.Winners),
+		Ranking:            slices.Clone(gs.Ranking),
 		GameOver:           gs.GameOver,
+		EndReason:          gs.EndReason,
 	}
 }
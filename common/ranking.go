@@ -0,0 +1,86 @@
+package common
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Final standing of a player, with the values used to break ties
+
+type Rank struct {
+	Player       int  `json:"player"`
+	Rank         int  `json:"rank"`
+	Flowers      uint `json:"flowers"`
+	FieldFlowers uint `json:"fieldFlowers"`
+	Bees         uint `json:"bees"`
+	Forfeited    bool `json:"forfeited,omitzero"`
+}
+
+// Players are ranked by flowers in reserve, then by flowers left in fields near
+// their hives, then by number of bees. Players who forfeited come last. Players
+// still tied after all criteria share the same rank.
+
+func compareRanks(a, b Rank) int {
+	return cmp.Or(
+		cmp.Compare(boolToInt(a.Forfeited), boolToInt(b.Forfeited)),
+		cmp.Compare(b.Flowers, a.Flowers),
+		cmp.Compare(b.FieldFlowers, a.FieldFlowers),
+		cmp.Compare(b.Bees, a.Bees),
+	)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (gs *GameState) fieldFlowersNear(player int) uint {
+	var flowers uint
+	for coords, hex := range gs.Hexes {
+		if hex.Terrain != FIELD || hex.Resources == 0 {
+			continue
+		}
+
+		for hcoords, hhex := range gs.Hexes {
+			entity := hhex.Entity
+			if entity != nil && entity.Type == HIVE && entity.Player == player &&
+				hcoords.Distance(coords) <= FIELD_OF_VIEW {
+				flowers += hex.Resources
+				break
+			}
+		}
+	}
+	return flowers
+}
+
+func (gs *GameState) Ranks() []Rank {
+	ranks := make([]Rank, gs.NumPlayers)
+	for player := range ranks {
+		ranks[player] = Rank{
+			Player:       player,
+			Flowers:      gs.PlayerResources[player],
+			FieldFlowers: gs.fieldFlowersNear(player),
+			Forfeited:    gs.HasForfeited(player),
+		}
+	}
+
+	for _, hex := range gs.Hexes {
+		if hex.Entity != nil && hex.Entity.Type == BEE {
+			ranks[hex.Entity.Player].Bees++
+		}
+	}
+
+	slices.SortStableFunc(ranks, compareRanks)
+
+	for i := range ranks {
+		if i > 0 && compareRanks(ranks[i-1], ranks[i]) == 0 {
+			ranks[i].Rank = ranks[i-1].Rank
+		} else {
+			ranks[i].Rank = i + 1
+		}
+	}
+
+	return ranks
+}
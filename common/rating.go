@@ -0,0 +1,164 @@
+package common
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+)
+
+const (
+	INITIAL_RATING = 1500.0
+	RATING_K       = 32.0
+)
+
+type RatingPoint struct {
+	GameID string    `json:"gameId"`
+	Date   time.Time `json:"date"`
+	Rating float64   `json:"rating"`
+}
+
+type AgentRating struct {
+	ID      string        `json:"id,omitempty"`
+	Name    string        `json:"name"`
+	Rating  float64       `json:"rating"`
+	Games   int           `json:"games"`
+	Wins    int           `json:"wins"`
+	WinRate float64       `json:"winRate"`
+	History []RatingPoint `json:"history,omitempty"`
+}
+
+// Multiplayer Elo ratings: a game between N players counts as a match between
+// every pair of players, decided by their final ranks, with the K factor shared
+// among the N-1 opponents of each player. Registered agents are keyed by their
+// ID, other agents by their name.
+
+type Ratings struct {
+	Agents map[string]*AgentRating
+}
+
+func NewRatings() *Ratings {
+	return &Ratings{Agents: make(map[string]*AgentRating)}
+}
+
+// Rates all games in chronological order
+
+func ComputeRatings(games []GameSummary) *Ratings {
+	games = slices.Clone(games)
+	slices.SortStableFunc(games, func(a, b GameSummary) int {
+		return a.CreatedDate.Compare(b.CreatedDate)
+	})
+
+	ratings := NewRatings()
+	for _, game := range games {
+		ratings.Update(game)
+	}
+	return ratings
+}
+
+func (ratings *Ratings) agent(game GameSummary, player int) *AgentRating {
+	key := game.AgentKey(player)
+	agent, found := ratings.Agents[key]
+	if !found {
+		agent = &AgentRating{Rating: INITIAL_RATING}
+		if key != game.Players[player] {
+			agent.ID = key
+		}
+		ratings.Agents[key] = agent
+	}
+
+	// Registered agents may be renamed: keep the latest name
+
+	agent.Name = game.Players[player]
+	return agent
+}
+
+// Ranks of each player, from the final ranking, or from the winners for games
+// saved before rankings were recorded
+
+func playerRanks(game GameSummary) []int {
+	ranks := make([]int, len(game.Players))
+
+	if game.Ranking != nil {
+		for _, rank := range game.Ranking {
+			ranks[rank.Player] = rank.Rank
+		}
+		return ranks
+	}
+
+	for player := range ranks {
+		ranks[player] = 2
+		if slices.Contains(game.Winners, player) {
+			ranks[player] = 1
+		}
+	}
+	return ranks
+}
+
+// Aborted games, games in which an agent plays against itself, practice games,
+// and games started from a scenario or forked from another game are not rated
+
+func IsRated(game GameSummary) bool {
+	var keys []string
+	for player := range game.Players {
+		keys = append(keys, game.AgentKey(player))
+	}
+	slices.Sort(keys)
+	return len(game.Players) > 1 && len(slices.Compact(keys)) == len(game.Players) && game.EndReason != ABORTED && game.Scenario == "" && game.ForkedFrom == nil && !game.Practice
+}
+
+func (ratings *Ratings) Update(game GameSummary) {
+	if !IsRated(game) {
+		return
+	}
+
+	ranks := playerRanks(game)
+	before := make([]float64, len(game.Players))
+	for player := range game.Players {
+		before[player] = ratings.agent(game, player).Rating
+	}
+
+	k := RATING_K / float64(len(game.Players)-1)
+
+	for player := range game.Players {
+		delta := 0.0
+		for opponent := range game.Players {
+			if opponent == player {
+				continue
+			}
+
+			expected := 1 / (1 + math.Pow(10, (before[opponent]-before[player])/400))
+			score := 0.5
+			if ranks[player] < ranks[opponent] {
+				score = 1
+			} else if ranks[player] > ranks[opponent] {
+				score = 0
+			}
+			delta += k * (score - expected)
+		}
+
+		agent := ratings.agent(game, player)
+		agent.Rating += delta
+		agent.Games++
+		if slices.Contains(game.Winners, player) {
+			agent.Wins++
+		}
+		agent.WinRate = float64(agent.Wins) / float64(agent.Games)
+		agent.History = append(agent.History, RatingPoint{game.Id, game.CreatedDate, agent.Rating})
+	}
+}
+
+// All agents, from the highest rating to the lowest
+
+func (ratings *Ratings) Leaderboard() []AgentRating {
+	var board []AgentRating
+	for _, agent := range ratings.Agents {
+		board = append(board, *agent)
+	}
+
+	slices.SortFunc(board, func(a, b AgentRating) int {
+		return cmp.Or(cmp.Compare(b.Rating, a.Rating), cmp.Compare(a.Name, b.Name))
+	})
+
+	return board
+}
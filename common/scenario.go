@@ -0,0 +1,155 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// A position to start a game from, such as an endgame or a skirmish. Hexes
+// have the same format as in the game state: terrain, remaining flowers of
+// fields, and entities with their owner, bees possibly carrying a flower.
+//
+// With a map, the scenario starts from the terrain of the map, with full fields
+// and no entities, and its hexes replace those of the map. Without one, the
+// hexes are the whole board.
+
+type Scenario struct {
+	Map             string          `json:"map,omitempty"`
+	NumPlayers      int             `json:"numPlayers"`
+	Turn            uint            `json:"turn"`
+	Hexes           map[Coords]*Hex `json:"hexes"`
+	PlayerResources []uint          `json:"playerResources,omitempty"`
+
+	// The turn of the last delivery, counting towards the end of the game when
+	// no flowers are delivered. The starting turn if missing.
+
+	LastResourceChange *uint `json:"lastResourceChange,omitempty"`
+}
+
+func LoadScenario(path string) (Scenario, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Scenario{}, err
+	}
+
+	var scenario Scenario
+	if err := json.Unmarshal(content, &scenario); err != nil {
+		return Scenario{}, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	return scenario, nil
+}
+
+// The position of a game on its current turn, with all its hexes
+
+func ScenarioOf(state *GameState) Scenario {
+	clone := state.Clone()
+	return Scenario{
+		NumPlayers:         clone.NumPlayers,
+		Turn:               clone.Turn,
+		Hexes:              clone.Hexes,
+		PlayerResources:    clone.PlayerResources,
+		LastResourceChange: &clone.LastResourceChange,
+	}
+}
+
+// Builds the starting state of the scenario. maps holds the maps it can refer
+// to.
+
+func (scenario Scenario) State(maps map[string]MapData) (*GameState, error) {
+	if !IsValidNumPlayers(scenario.NumPlayers) {
+		return nil, fmt.Errorf("invalid number of players: %d", scenario.NumPlayers)
+	}
+
+	state := &GameState{
+		NumPlayers:         scenario.NumPlayers,
+		Turn:               scenario.Turn,
+		Hexes:              make(map[Coords]*Hex),
+		PlayerResources:    make([]uint, scenario.NumPlayers),
+		LastResourceChange: scenario.Turn,
+	}
+
+	if scenario.Map != "" {
+		mapdata, found := maps[scenario.Map]
+		if !found {
+			return nil, fmt.Errorf("map not found: %s", scenario.Map)
+		}
+		for coords, terrain := range mapdata.Map {
+			state.Hexes[coords] = &Hex{Terrain: terrain}
+			if terrain == FIELD {
+				state.Hexes[coords].Resources = INIT_FIELD_FLOWERS
+			}
+		}
+	}
+
+	for coords, hex := range scenario.Hexes {
+		if hex == nil {
+			return nil, fmt.Errorf("missing hex at %s", coords)
+		}
+		if err := hex.validate(scenario.NumPlayers); err != nil {
+			return nil, fmt.Errorf("invalid hex at %s: %w", coords, err)
+		}
+
+		clone := *hex
+		if hex.Entity != nil {
+			entity := *hex.Entity
+			clone.Entity = &entity
+		}
+		state.Hexes[coords] = &clone
+	}
+
+	if len(state.Hexes) == 0 {
+		return nil, fmt.Errorf("no hexes")
+	}
+
+	if scenario.PlayerResources != nil {
+		if len(scenario.PlayerResources) != scenario.NumPlayers {
+			return nil, fmt.Errorf("expected resources for %d players, got %d", scenario.NumPlayers, len(scenario.PlayerResources))
+		}
+		copy(state.PlayerResources, scenario.PlayerResources)
+	}
+
+	if scenario.LastResourceChange != nil {
+		if *scenario.LastResourceChange > scenario.Turn {
+			return nil, fmt.Errorf("last resource change after the starting turn: %d", *scenario.LastResourceChange)
+		}
+		state.LastResourceChange = *scenario.LastResourceChange
+	}
+
+	state.checkEndGame()
+
+	return state, nil
+}
+
+func (hex *Hex) validate(players int) error {
+	switch hex.Terrain {
+	case EMPTY, ROCK, FIELD:
+	default:
+		return fmt.Errorf("invalid terrain: %q", hex.Terrain)
+	}
+
+	if hex.Resources > 0 && hex.Terrain != FIELD {
+		return fmt.Errorf("resources on %s", hex.Terrain)
+	}
+
+	entity := hex.Entity
+	if entity == nil {
+		return nil
+	}
+
+	switch entity.Type {
+	case BEE, HIVE, WALL:
+	default:
+		return fmt.Errorf("invalid entity type: %q", entity.Type)
+	}
+	if entity.Player < 0 || entity.Player >= players {
+		return fmt.Errorf("invalid player: %d", entity.Player)
+	}
+	if !hex.Terrain.IsWalkable() {
+		return fmt.Errorf("%s on %s", entity.Type, hex.Terrain)
+	}
+	if entity.HasFlower && entity.Type != BEE {
+		return fmt.Errorf("%s carrying a flower", entity.Type)
+	}
+	return nil
+}
@@ -1,7 +1,9 @@
 package common
 
 import (
+	"encoding/json"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -37,24 +39,174 @@ func (c *Coords) UnmarshalText(b []byte) error {
 	return c.FromString(string(b))
 }
 
+// Durations are serialized as a number of milliseconds
+
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).Milliseconds())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var ms int64
+	if err := json.Unmarshal(b, &ms); err != nil {
+		return fmt.Errorf("invalid duration value: %w", err)
+	}
+	*d = Duration(time.Duration(ms) * time.Millisecond)
+	return nil
+}
+
 type PersistedGame struct {
-	Id          string    `json:"id"`
-	Map         string    `json:"map"`
-	CreatedDate time.Time `json:"createdDate"`
-	Players     []string  `json:"players"`
-	History     []Turn    `json:"history"`
+	Id          string        `json:"id"`
+	Map         string        `json:"map"`
+	Scenario    string        `json:"scenario,omitempty"`
+	CreatedDate time.Time     `json:"createdDate"`
+	Players     []string      `json:"players"`
+	AgentIds    []string      `json:"agentIds,omitempty"`
+	SeatBots    []string      `json:"seatBots,omitempty"`
+	Seed        int64         `json:"seed,omitzero"`
+	Timing      TurnTiming    `json:"timing"`
+	ForkedFrom  *ForkOrigin   `json:"forkedFrom,omitempty"`
+	Practice    bool          `json:"practice,omitempty"`
+	History     []Turn        `json:"history"`
+	Stats       []PlayerStats `json:"stats,omitempty"`
+}
+
+// The game and turn a game was forked from
+
+type ForkOrigin struct {
+	Game string `json:"game"`
+	Turn uint   `json:"turn"`
+}
+
+// A lightweight description of a finished game
+
+type GameSummary struct {
+	Id          string      `json:"id"`
+	Map         string      `json:"map"`
+	Scenario    string      `json:"scenario,omitempty"`
+	ForkedFrom  *ForkOrigin `json:"forkedFrom,omitempty"`
+	Practice    bool        `json:"practice,omitempty"`
+	CreatedDate time.Time   `json:"createdDate"`
+	Players     []string    `json:"players"`
+	AgentIds    []string    `json:"agentIds,omitempty"`
+	Winners     []int       `json:"winners"`
+	Ranking     []Rank      `json:"ranking,omitempty"`
+	Turns       uint        `json:"turns"`
+	EndReason   EndReason   `json:"endReason"`
+}
+
+func (game *PersistedGame) Summary() GameSummary {
+	summary := GameSummary{
+		Id:          game.Id,
+		Map:         game.Map,
+		Scenario:    game.Scenario,
+		ForkedFrom:  game.ForkedFrom,
+		Practice:    game.Practice,
+		CreatedDate: game.CreatedDate,
+		Players:     game.Players,
+		AgentIds:    game.AgentIds,
+	}
+
+	if len(game.History) > 0 {
+		final := game.History[len(game.History)-1].State
+		summary.Winners = final.Winners
+		summary.Ranking = final.Ranking
+		summary.Turns = final.Turn
+		summary.EndReason = final.EndReason
+	}
+
+	return summary
+}
+
+// The stable identity of a player: the ID of its registered agent, or its name
+// for unregistered agents
+
+func (game GameSummary) AgentKey(player int) string {
+	if player < len(game.AgentIds) && game.AgentIds[player] != "" {
+		return game.AgentIds[player]
+	}
+	return game.Players[player]
+}
+
+// Writes the game to a history directory, and returns the path of the file
+
+func (game *PersistedGame) Save(dir string) (string, error) {
+	date, _ := game.CreatedDate.MarshalText()
+	path := fmt.Sprintf("%s/%s-%s-%s.json", dir, date, game.Id, game.Map)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if err := json.NewEncoder(file).Encode(game); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func LoadPersistedGame(path string) (*PersistedGame, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var game PersistedGame
+	if err := json.NewDecoder(file).Decode(&game); err != nil {
+		return nil, fmt.Errorf("invalid game file %s: %w", path, err)
+	}
+	return &game, nil
 }
 
 type Turn struct {
 	Orders []*Order   `json:"orders,omitempty"`
 	State  *GameState `json:"state"`
+	Events []Event    `json:"events,omitempty"`
+}
+
+// Changes to the seats of a game, and edits of practice games, recorded with
+// the turn during which they happened
+
+type EventType string
+
+const (
+	FORFEITED    EventType = "FORFEITED"
+	INACTIVE     EventType = "INACTIVE"
+	BOT_TAKEOVER EventType = "BOT_TAKEOVER"
+	RECONNECTED  EventType = "RECONNECTED"
+
+	// Changes to the board of a practice game, with the player concerned, or -1
+
+	EDITED EventType = "EDITED"
+)
+
+type Event struct {
+	Type   EventType `json:"type"`
+	Player int       `json:"player"`
+	Turn   uint      `json:"turn"`
+	Detail string    `json:"detail,omitempty"`
 }
 
 type SessionStatus struct {
-	Id          string    `json:"id"`
-	CreatedDate time.Time `json:"createdDate"`
-	Map         string    `json:"map"`
-	NumPlayers  int       `json:"numPlayers"`
-	Players     []string  `json:"players"`
-	GameOver    bool      `json:"gameOver"`
+	Id          string      `json:"id"`
+	CreatedDate time.Time   `json:"createdDate"`
+	Map         string      `json:"map"`
+	Scenario    string      `json:"scenario,omitempty"`
+	ForkedFrom  *ForkOrigin `json:"forkedFrom,omitempty"`
+	Practice    bool        `json:"practice,omitempty"`
+	NumPlayers  int         `json:"numPlayers"`
+	Players     []string    `json:"players"`
+	AgentIds    []string    `json:"agentIds,omitempty"`
+	Bots        []int       `json:"bots,omitempty"`
+	Inactive    []int       `json:"inactive,omitempty"`
+	MissedTurns []int       `json:"missedTurns,omitempty"`
+	Timing      TurnTiming  `json:"timing"`
+	Paused      bool        `json:"paused"`
+	GameOver    bool        `json:"gameOver"`
+	EndReason   EndReason   `json:"endReason,omitempty"`
+	Winners     []int       `json:"winners,omitempty"`
+	Ranking     []Rank      `json:"ranking,omitempty"`
 }
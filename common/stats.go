@@ -0,0 +1,118 @@
+package common
+
+type PlayerStats struct {
+	Player         int    `json:"player"`
+	FlowersPerTurn []uint `json:"flowersPerTurn"`
+
+	BeesSpawned    int `json:"beesSpawned"`
+	HivesBuilt     int `json:"hivesBuilt"`
+	WallsBuilt     int `json:"wallsBuilt"`
+	WallsDestroyed int `json:"wallsDestroyed"`
+
+	AttacksAttempted int `json:"attacksAttempted"`
+	AttacksLanded    int `json:"attacksLanded"`
+	StunsSuffered    int `json:"stunsSuffered"`
+
+	OrderFailures map[OrderStatus]int `json:"orderFailures"`
+
+	Deliveries        int     `json:"deliveries"`
+	AverageTripLength float64 `json:"averageTripLength"`
+}
+
+// A flower picked up at an unknown turn, for bees already carrying one in the first state
+
+const unknownPickup = -1
+
+func ComputeStats(history []Turn) []PlayerStats {
+	if len(history) == 0 {
+		return nil
+	}
+
+	numPlayers := history[0].State.NumPlayers
+	stats := make([]PlayerStats, numPlayers)
+	for player := range stats {
+		stats[player].Player = player
+		stats[player].OrderFailures = make(map[OrderStatus]int)
+	}
+
+	// Follow each flower from the turn it is picked up, through the moves of
+	// its bee, until it is dropped in a hive
+
+	carrying := make(map[Coords]int)
+	tripTurns := make([]int, numPlayers)
+	trips := make([]int, numPlayers)
+
+	for coords, hex := range history[0].State.Hexes {
+		if hex.Entity != nil && hex.Entity.HasFlower {
+			carrying[coords] = unknownPickup
+		}
+	}
+
+	for i, turn := range history {
+		for player := range stats {
+			stats[player].FlowersPerTurn = append(stats[player].FlowersPerTurn, turn.State.PlayerResources[player])
+		}
+
+		if i == 0 {
+			continue
+		}
+		turnNumber := int(history[i-1].State.Turn)
+
+		for _, order := range turn.Orders {
+			player := &stats[order.Player]
+
+			if order.Type == ATTACK {
+				player.AttacksAttempted++
+			}
+
+			if order.Status != OK {
+				player.OrderFailures[order.Status]++
+				continue
+			}
+
+			switch order.Type {
+			case SPAWN:
+				player.BeesSpawned++
+			case BUILD_HIVE:
+				player.HivesBuilt++
+				delete(carrying, order.Coords)
+			case BUILD_WALL:
+				player.WallsBuilt++
+			case ATTACK:
+				if order.Hit == nil {
+					break
+				}
+				player.AttacksLanded++
+				if order.Hit.Type == WALL {
+					player.WallsDestroyed++
+				} else if order.Hit.Type == BEE {
+					stats[order.Hit.Player].StunsSuffered++
+				}
+			case MOVE:
+				if pickup, ok := carrying[order.Coords]; ok {
+					delete(carrying, order.Coords)
+					carrying[order.Target()] = pickup
+				}
+			case FORAGE:
+				if pickup, ok := carrying[order.Coords]; ok {
+					delete(carrying, order.Coords)
+					player.Deliveries++
+					if pickup != unknownPickup {
+						trips[order.Player]++
+						tripTurns[order.Player] += turnNumber - pickup
+					}
+				} else {
+					carrying[order.Coords] = turnNumber
+				}
+			}
+		}
+	}
+
+	for player := range stats {
+		if trips[player] > 0 {
+			stats[player].AverageTripLength = float64(tripTurns[player]) / float64(trips[player])
+		}
+	}
+
+	return stats
+}
@@ -28,15 +28,35 @@ type Coords struct {
 	Col int
 }
 
+// Same as DirectionToOffset, without hashing: this is called a lot by bots
+
+func offset(dir Direction) Coords {
+	switch dir {
+	case E:
+		return Coords{0, 2}
+	case NE:
+		return Coords{-1, 1}
+	case NW:
+		return Coords{-1, -1}
+	case W:
+		return Coords{0, -2}
+	case SW:
+		return Coords{1, -1}
+	case SE:
+		return Coords{1, 1}
+	}
+	return Coords{}
+}
+
 func (c Coords) Neighbour(dir Direction) Coords {
-	offset := DirectionToOffset[dir]
+	offset := offset(dir)
 	return Coords{Row: c.Row + offset.Row, Col: c.Col + offset.Col}
 }
 
 func (c Coords) Neighbours() []Coords {
 	neighbors := make([]Coords, 0, 6)
-	for _, offset := range DirectionToOffset {
-		neighbors = append(neighbors, Coords{Row: c.Row + offset.Row, Col: c.Col + offset.Col})
+	for _, dir := range Directions {
+		neighbors = append(neighbors, c.Neighbour(dir))
 	}
 	return neighbors
 }
@@ -71,6 +91,10 @@ var DirectionToOffset = map[Direction]Coords{
 	SE: {1, 1},
 }
 
+// All directions, clockwise from east
+
+var Directions = []Direction{E, SE, SW, W, NW, NE}
+
 type Spawn struct {
 	Kind   EntityType
 	Player int
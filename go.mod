@@ -5,6 +5,8 @@ go 1.25.1
 require (
 	github.com/gorilla/websocket v1.5.3
 	github.com/hajimehoshi/ebiten/v2 v2.9.4
+	github.com/tetratelabs/wazero v1.10.1
+	github.com/yuin/gopher-lua v1.1.1
 )
 
 require (
@@ -0,0 +1,161 @@
+package gym
+
+import (
+	"fmt"
+	"slices"
+
+	. "hive-arena/common"
+)
+
+// Actions of the unit on one hex. Directional actions come in the order of
+// common.Directions.
+
+const (
+	ACTION_MOVE       = 0
+	ACTION_ATTACK     = 6
+	ACTION_BUILD_WALL = 12
+	ACTION_SPAWN      = 18
+	ACTION_FORAGE     = 24
+	ACTION_BUILD_HIVE = 25
+	ACTIONS_PER_HEX   = 26
+)
+
+var directionalActions = map[OrderType]int{
+	MOVE:       ACTION_MOVE,
+	ATTACK:     ACTION_ATTACK,
+	BUILD_WALL: ACTION_BUILD_WALL,
+	SPAWN:      ACTION_SPAWN,
+}
+
+// Flat action indices: hex index * ACTIONS_PER_HEX + action, with hexes
+// numbered row by row on the observation grid. Parity is (row + col) % 2 for
+// all hexes of the map, which is needed to turn grid cells back into doubled
+// coordinates.
+
+type ActionSpace struct {
+	Rows   int `json:"rows"`
+	Cols   int `json:"cols"`
+	Parity int `json:"parity"`
+}
+
+func (space ActionSpace) Size() int {
+	return space.Rows * space.Cols * ACTIONS_PER_HEX
+}
+
+func (space ActionSpace) Contains(coords Coords) bool {
+	return coords.Row >= 0 && coords.Row < space.Rows && coords.Col >= 0 && coords.Col/2 < space.Cols
+}
+
+func (space ActionSpace) Order(action int) (*Order, error) {
+	if action < 0 || action >= space.Size() {
+		return nil, fmt.Errorf("invalid action: %d", action)
+	}
+
+	cell, kind := action/ACTIONS_PER_HEX, action%ACTIONS_PER_HEX
+	row, col := cell/space.Cols, cell%space.Cols
+	coords := Coords{Row: row, Col: 2*col + (row+space.Parity)%2}
+
+	switch {
+	case kind == ACTION_FORAGE:
+		return &Order{Type: FORAGE, Coords: coords}, nil
+	case kind == ACTION_BUILD_HIVE:
+		return &Order{Type: BUILD_HIVE, Coords: coords}, nil
+	}
+
+	for orderType, first := range directionalActions {
+		if kind >= first && kind < first+len(Directions) {
+			return &Order{Type: orderType, Coords: coords, Direction: Directions[kind-first]}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid action: %d", action)
+}
+
+func (space ActionSpace) Action(order *Order) (int, error) {
+	if !space.Contains(order.Coords) {
+		return 0, fmt.Errorf("coordinates outside of the grid: %v", order.Coords)
+	}
+	cell := order.Coords.Row*space.Cols + order.Coords.Col/2
+
+	switch order.Type {
+	case FORAGE:
+		return cell*ACTIONS_PER_HEX + ACTION_FORAGE, nil
+	case BUILD_HIVE:
+		return cell*ACTIONS_PER_HEX + ACTION_BUILD_HIVE, nil
+	}
+
+	first, found := directionalActions[order.Type]
+	dir := slices.Index(Directions, order.Direction)
+	if !found || dir == -1 {
+		return 0, fmt.Errorf("invalid order: %s %s", order.Type, order.Direction)
+	}
+	return cell*ACTIONS_PER_HEX + first + dir, nil
+}
+
+// Actions of the player's units whose unit, target and cost are right, as far
+// as the view tells. They can still fail, when other players get there first.
+
+func (space ActionSpace) legal(view *GameState, player int) []int {
+	var legal []int
+	add := func(order *Order) {
+		if action, err := space.Action(order); err == nil {
+			legal = append(legal, action)
+		}
+	}
+
+	flowers := view.PlayerResources[0]
+	isFree := func(coords Coords) bool {
+		hex := view.Hexes[coords]
+		return hex != nil && hex.Terrain.IsWalkable() && hex.Entity == nil
+	}
+
+	for coords, hex := range view.Hexes {
+		unit := hex.Entity
+		if unit == nil || unit.Player != player {
+			continue
+		}
+
+		for _, dir := range Directions {
+			target := coords.Neighbour(dir)
+
+			switch unit.Type {
+			case BEE:
+				if isFree(target) {
+					add(&Order{Type: MOVE, Coords: coords, Direction: dir})
+					if flowers >= WALL_COST {
+						add(&Order{Type: BUILD_WALL, Coords: coords, Direction: dir})
+					}
+				}
+				if other := view.EntityAt(target); other != nil {
+					add(&Order{Type: ATTACK, Coords: coords, Direction: dir})
+				}
+			case HIVE:
+				if isFree(target) && flowers >= BEE_COST {
+					add(&Order{Type: SPAWN, Coords: coords, Direction: dir})
+				}
+			}
+		}
+
+		if unit.Type != BEE {
+			continue
+		}
+		if flowers >= HIVE_COST {
+			add(&Order{Type: BUILD_HIVE, Coords: coords})
+		}
+		if unit.HasFlower && nextToHive(view, coords, player) || !unit.HasFlower && hex.Terrain == FIELD && hex.Resources > 0 {
+			add(&Order{Type: FORAGE, Coords: coords})
+		}
+	}
+
+	slices.Sort(legal)
+	return legal
+}
+
+func nextToHive(view *GameState, coords Coords, player int) bool {
+	for _, n := range coords.Neighbours() {
+		entity := view.EntityAt(n)
+		if entity != nil && entity.Type == HIVE && entity.Player == player {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package gym
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"hive-arena/bots"
+	. "hive-arena/common"
+)
+
+// Lets programs in other languages drive an environment, one JSON document per
+// line in each direction. See readme.md for the protocol.
+
+const MaxRequestLength = 16 * 1024 * 1024
+
+type Request struct {
+	Command   string   `json:"command"`
+	Seed      int64    `json:"seed"`
+	Map       string   `json:"map"`
+	Opponents []string `json:"opponents"`
+	Actions   []int    `json:"actions"`
+	Orders    []*Order `json:"orders"`
+}
+
+type Spec struct {
+	Channels      int         `json:"channels"`
+	Space         ActionSpace `json:"space"`
+	ActionsPerHex int         `json:"actionsPerHex"`
+	Actions       int         `json:"actions"`
+	Maps          []string    `json:"maps"`
+	Bots          []string    `json:"bots"`
+}
+
+type Response struct {
+	Spec        *Spec        `json:"spec,omitempty"`
+	Observation *Observation `json:"observation,omitempty"`
+	Reward      float64      `json:"reward"`
+	Done        bool         `json:"done"`
+	Ranking     []Rank       `json:"ranking,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+func (env *Env) handle(request Request) Response {
+	var observation Observation
+	var reward float64
+	var done bool
+	var err error
+
+	switch request.Command {
+	case "spec":
+		return Response{Spec: &Spec{
+			Channels:      NUM_CHANNELS,
+			Space:         env.space,
+			ActionsPerHex: ACTIONS_PER_HEX,
+			Actions:       env.space.Size(),
+			Maps:          env.MapNames(),
+			Bots:          bots.Names(),
+		}}
+	case "reset":
+		observation, err = env.Reset(request.Seed, request.Map, request.Opponents)
+	case "step":
+		if request.Orders != nil {
+			observation, reward, done, err = env.Step(request.Orders)
+		} else {
+			observation, reward, done, err = env.StepActions(request.Actions)
+		}
+	default:
+		return Response{Error: "Invalid command: " + request.Command}
+	}
+
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+
+	response := Response{Observation: &observation, Reward: reward, Done: done}
+	if done {
+		response.Ranking = env.Ranking()
+	}
+	return response
+}
+
+// Answers requests until the input is closed
+
+func (env *Env) Serve(input io.Reader, output io.Writer) error {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(nil, MaxRequestLength)
+	encoder := json.NewEncoder(output)
+
+	for scanner.Scan() {
+		var request Request
+		response := Response{}
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			response.Error = "Invalid request: " + err.Error()
+		} else {
+			response = env.handle(request)
+		}
+
+		if err := encoder.Encode(response); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
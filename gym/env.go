@@ -0,0 +1,177 @@
+package gym
+
+import (
+	"fmt"
+	"maps"
+	"math/rand"
+	"slices"
+
+	"hive-arena/bots"
+	. "hive-arena/common"
+)
+
+// A reinforcement learning environment: one learning player against built-in
+// bots, stepping through a game one turn at a time. The grid is the same for
+// all maps, large enough for the largest one, so that observations and actions
+// keep the same size.
+
+type Env struct {
+	Maps  map[string]MapData
+	space ActionSpace
+
+	state     *GameState
+	player    int
+	parity    int
+	opponents map[int]Agent
+}
+
+func NewEnv(maps map[string]MapData) *Env {
+	env := &Env{Maps: maps}
+	for _, mapdata := range maps {
+		for coords := range mapdata.Map {
+			env.space.Rows = max(env.space.Rows, coords.Row+1)
+			env.space.Cols = max(env.space.Cols, coords.Col/2+1)
+		}
+	}
+	return env
+}
+
+func (env *Env) Space() ActionSpace {
+	return env.space
+}
+
+func (env *Env) MapNames() []string {
+	return slices.Sorted(maps.Keys(env.Maps))
+}
+
+// Starts a new game. The learning player gets a seat picked from the seed, and
+// the opponents the other seats, in order. Everything that follows only depends
+// on the seed and the orders given.
+
+func (env *Env) Reset(seed int64, mapname string, opponents []string) (Observation, error) {
+	mapdata, found := env.Maps[mapname]
+	if !found {
+		return Observation{}, fmt.Errorf("invalid map: %s", mapname)
+	}
+
+	players := len(opponents) + 1
+	state := NewGameState(mapdata, players)
+	if state == nil {
+		return Observation{}, fmt.Errorf("invalid number of players: %d", players)
+	}
+	state.SetSeed(seed)
+
+	player := rand.New(rand.NewSource(seed)).Intn(players)
+	agents := make(map[int]Agent)
+	for i, name := range opponents {
+		seat := i
+		if seat >= player {
+			seat++
+		}
+
+		bot, err := bots.NewSeeded(name, seed+int64(seat))
+		if err != nil {
+			return Observation{}, err
+		}
+		agents[seat] = bot
+	}
+
+	parity := 0
+	for coords := range mapdata.Map {
+		parity = (coords.Row + coords.Col) % 2
+		break
+	}
+
+	env.state = state
+	env.player = player
+	env.parity = parity
+	env.opponents = agents
+
+	return env.observe(), nil
+}
+
+// The space is shared by all games, and only gets the parity of the current
+// map for converting its observations and actions
+
+func (env *Env) gameSpace() ActionSpace {
+	space := env.space
+	space.Parity = env.parity
+	return space
+}
+
+func (env *Env) observe() Observation {
+	return env.gameSpace().Observe(env.state.PlayerView(env.player), env.player)
+}
+
+// Plays a turn with the learning player's orders. The reward is only given at
+// the end of the game: 1 for the first rank, -1 for the last, and in between in
+// proportion for more than 2 players.
+
+func (env *Env) Step(orders []*Order) (Observation, float64, bool, error) {
+	if env.state == nil || env.state.GameOver {
+		return Observation{}, 0, true, fmt.Errorf("no game in progress, call Reset first")
+	}
+
+	all := make([][]*Order, env.state.NumPlayers)
+	all[env.player] = orders
+	for seat, bot := range env.opponents {
+		all[seat] = think(bot, env.state.PlayerView(seat), seat)
+	}
+
+	if _, err := env.state.ProcessOrders(all); err != nil {
+		return Observation{}, 0, true, err
+	}
+
+	observation := env.observe()
+	if !env.state.GameOver {
+		return observation, 0, false, nil
+	}
+	return observation, env.reward(), true, nil
+}
+
+func (env *Env) StepActions(actions []int) (Observation, float64, bool, error) {
+	var orders []*Order
+	for _, action := range actions {
+		order, err := env.gameSpace().Order(action)
+		if err != nil {
+			return Observation{}, 0, false, err
+		}
+		orders = append(orders, order)
+	}
+	return env.Step(orders)
+}
+
+func (env *Env) reward() float64 {
+	players := env.state.NumPlayers
+	for _, rank := range env.state.Ranking {
+		if rank.Player == env.player && players > 1 {
+			return 1 - 2*float64(rank.Rank-1)/float64(players-1)
+		}
+	}
+	return 0
+}
+
+// The final ranking, once the game is over
+
+func (env *Env) Ranking() []Rank {
+	if env.state == nil {
+		return nil
+	}
+	return env.state.Ranking
+}
+
+// Opponents that fail play no orders for the turn
+
+func think(bot Agent, view *GameState, player int) (orders []*Order) {
+	defer func() {
+		if recover() != nil {
+			orders = nil
+		}
+	}()
+
+	orders, err := bot.Think(view, player)
+	if err != nil {
+		return nil
+	}
+	return orders
+}
@@ -0,0 +1,104 @@
+package gym
+
+import (
+	. "hive-arena/common"
+)
+
+// Channels of the observation tensor. Hexes outside the player's view are all
+// zero, VISIBLE included.
+
+const (
+	VISIBLE = iota
+	TERRAIN_EMPTY
+	TERRAIN_ROCK
+	TERRAIN_FIELD
+	RESOURCES
+	OWN_BEE
+	OWN_HIVE
+	OWN_WALL
+	ENEMY_BEE
+	ENEMY_HIVE
+	ENEMY_WALL
+	CARRYING_FLOWER
+	NUM_CHANNELS
+)
+
+// The player's view as a tensor of shape (NUM_CHANNELS, Rows, Cols), flattened
+// in that order. Hexes are laid out on the grid by row, and by column divided
+// by 2, since columns are doubled coordinates.
+
+type Observation struct {
+	Player  int       `json:"player"`
+	Turn    uint      `json:"turn"`
+	Flowers uint      `json:"flowers"`
+	Parity  int       `json:"parity"`
+	Shape   [3]int    `json:"shape"`
+	Tensor  []float32 `json:"tensor"`
+
+	// Actions that may succeed, as far as the view tells
+
+	Legal []int `json:"legal"`
+}
+
+func (space ActionSpace) index(channel int, coords Coords) int {
+	return (channel*space.Rows+coords.Row)*space.Cols + coords.Col/2
+}
+
+func (space ActionSpace) Observe(view *GameState, player int) Observation {
+	observation := Observation{
+		Player:  player,
+		Turn:    view.Turn,
+		Flowers: view.PlayerResources[0],
+		Parity:  space.Parity,
+		Shape:   [3]int{NUM_CHANNELS, space.Rows, space.Cols},
+		Tensor:  make([]float32, NUM_CHANNELS*space.Rows*space.Cols),
+		Legal:   space.legal(view, player),
+	}
+
+	set := func(channel int, coords Coords, value float32) {
+		observation.Tensor[space.index(channel, coords)] = value
+	}
+
+	for coords, hex := range view.Hexes {
+		if !space.Contains(coords) {
+			continue
+		}
+
+		set(VISIBLE, coords, 1)
+		switch hex.Terrain {
+		case EMPTY:
+			set(TERRAIN_EMPTY, coords, 1)
+		case ROCK:
+			set(TERRAIN_ROCK, coords, 1)
+		case FIELD:
+			set(TERRAIN_FIELD, coords, 1)
+		}
+		set(RESOURCES, coords, float32(hex.Resources)/INIT_FIELD_FLOWERS)
+
+		entity := hex.Entity
+		if entity == nil {
+			continue
+		}
+
+		own := entity.Player == player
+		switch {
+		case entity.Type == BEE && own:
+			set(OWN_BEE, coords, 1)
+		case entity.Type == HIVE && own:
+			set(OWN_HIVE, coords, 1)
+		case entity.Type == WALL && own:
+			set(OWN_WALL, coords, 1)
+		case entity.Type == BEE:
+			set(ENEMY_BEE, coords, 1)
+		case entity.Type == HIVE:
+			set(ENEMY_HIVE, coords, 1)
+		case entity.Type == WALL:
+			set(ENEMY_WALL, coords, 1)
+		}
+		if entity.HasFlower {
+			set(CARRYING_FLOWER, coords, 1)
+		}
+	}
+
+	return observation
+}
@@ -0,0 +1,341 @@
+package luaagent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+
+	. "hive-arena/common"
+)
+
+// Largest Lua stack and registry, in slots, to stop runaway recursion, and
+// longest string string.rep can build, in bytes
+const (
+	CallStackSize   = 1024
+	RegistrySize    = 1024
+	RegistryMaxSize = 1 << 20
+	MaxRepLength    = 1 << 20
+)
+
+// A Script is an agent written in Lua against the API of example-agent-lua, running in a
+// sandboxed interpreter. The script registers its callback through the usual
+// arena.runAgent(host, gameid, name, callback), which only records it here, or
+// by returning it. Each turn, the callback gets the player's view as a table,
+// decoded from JSON like lunajson does, and returns a table of orders.
+
+type Script struct {
+	mutex sync.Mutex
+
+	Timeout time.Duration
+
+	state    *lua.LState
+	callback *lua.LFunction
+}
+
+// Files that a script can read: the modules next to it
+
+func modules(dir string) (map[string]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.lua"))
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make(map[string]string)
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sources[strings.TrimSuffix(filepath.Base(path), ".lua")] = string(source)
+	}
+	return sources, nil
+}
+
+// Opens the safe parts of the standard library. There is no access to files,
+// processes or the environment, and print and io.write go to output.
+
+func openLibs(L *lua.LState, output io.Writer) {
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.LoadLibName, lua.OpenPackage},
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+		{lua.CoroutineLibName, lua.OpenCoroutine},
+		{lua.OsLibName, lua.OpenOs},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	for _, name := range []string{"dofile", "loadfile"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	// string.rep would otherwise allocate strings of any length at once
+
+	L.SetField(L.GetGlobal("string"), "rep", L.NewFunction(func(L *lua.LState) int {
+		str := L.CheckString(1)
+		n := L.CheckInt(2)
+		if len(str) > 0 && n > MaxRepLength/len(str) {
+			L.RaiseError("string.rep longer than %d bytes", MaxRepLength)
+		}
+		L.Push(lua.LString(strings.Repeat(str, max(n, 0))))
+		return 1
+	}))
+
+	// Only the clock functions of os are kept
+
+	safeOs := L.NewTable()
+	for _, name := range []string{"clock", "date", "difftime", "time"} {
+		L.SetField(safeOs, name, L.GetField(L.GetGlobal("os"), name))
+	}
+	L.SetGlobal("os", safeOs)
+
+	write := func(L *lua.LState) int {
+		var parts []string
+		for i := 1; i <= L.GetTop(); i++ {
+			parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+		}
+		fmt.Fprint(output, strings.Join(parts, ""))
+		return 0
+	}
+
+	L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int {
+		var parts []string
+		for i := 1; i <= L.GetTop(); i++ {
+			parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+		}
+		fmt.Fprintln(output, strings.Join(parts, "\t"))
+		return 0
+	}))
+
+	safeIo := L.NewTable()
+	L.SetField(safeIo, "write", L.NewFunction(write))
+	L.SetGlobal("io", safeIo)
+
+	// require only finds preloaded modules
+
+	pkg := L.GetGlobal("package").(*lua.LTable)
+	loaders := L.GetField(pkg, "loaders").(*lua.LTable)
+	for loaders.Len() > 1 {
+		loaders.Remove(loaders.Len())
+	}
+	L.SetField(pkg, "path", lua.LString(""))
+	L.SetField(pkg, "loadlib", lua.LNil)
+}
+
+// Loads the agent from its main script, which can require the other scripts
+// of its directory. An arena.lua module there is replaced by the built-in one.
+// Output of the agent goes to output, which can be nil.
+
+func Load(path string, output io.Writer, timeout time.Duration) (*Script, error) {
+	if output == nil {
+		output = io.Discard
+	}
+
+	sources, err := modules(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	main, found := sources[strings.TrimSuffix(filepath.Base(path), ".lua")]
+	if !found {
+		return nil, fmt.Errorf("no such script: %s", path)
+	}
+
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:    true,
+		CallStackSize:   CallStackSize,
+		RegistrySize:    RegistrySize,
+		RegistryMaxSize: RegistryMaxSize,
+	})
+	openLibs(L, output)
+
+	agent := &Script{Timeout: timeout, state: L}
+
+	preload := L.GetField(L.GetGlobal("package"), "preload").(*lua.LTable)
+	for name, source := range sources {
+		chunk, err := L.LoadString(source)
+		if err != nil {
+			L.Close()
+			return nil, err
+		}
+		L.SetField(preload, name, chunk)
+	}
+	L.SetField(preload, "arena", L.NewFunction(agent.arenaModule))
+
+	chunk, err := L.LoadString(main)
+	if err != nil {
+		L.Close()
+		return nil, err
+	}
+
+	// The script runs as with 'lua main.lua <host> <gameid> <name>'
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	L.Push(chunk)
+	for _, arg := range []string{"local", "local", "agent"} {
+		L.Push(lua.LString(arg))
+	}
+	if err := L.PCall(3, 1, nil); err != nil {
+		L.Close()
+		return nil, err
+	}
+
+	if returned, ok := L.Get(-1).(*lua.LFunction); ok && agent.callback == nil {
+		agent.callback = returned
+	}
+	L.Pop(1)
+
+	if agent.callback == nil {
+		L.Close()
+		return nil, errors.New("the script neither called arena.runAgent nor returned a function")
+	}
+
+	return agent, nil
+}
+
+func (agent *Script) arenaModule(L *lua.LState) int {
+	module := L.NewTable()
+	L.SetField(module, "runAgent", L.NewFunction(func(L *lua.LState) int {
+		agent.callback = L.CheckFunction(4)
+		return 0
+	}))
+	L.Push(module)
+	return 1
+}
+
+func (agent *Script) Think(view *GameState, player int) ([]*Order, error) {
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+
+	if agent.state == nil {
+		return nil, errors.New("agent closed")
+	}
+	L := agent.state
+
+	data, err := json.Marshal(view)
+	if err != nil {
+		return nil, err
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), agent.Timeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	L.Push(agent.callback)
+	L.Push(toLua(L, decoded))
+	L.Push(lua.LNumber(player))
+	if err := L.PCall(2, 1, nil); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("agent timed out after %s", agent.Timeout)
+		}
+		return nil, err
+	}
+	result := L.Get(-1)
+	L.Pop(1)
+
+	data, err = json.Marshal(fromLua(result))
+	if err != nil {
+		return nil, err
+	}
+
+	var orders []*Order
+	if err := json.Unmarshal(data, &orders); err != nil {
+		return nil, fmt.Errorf("invalid orders from agent: %w", err)
+	}
+	return orders, nil
+}
+
+func (agent *Script) Close() error {
+	agent.mutex.Lock()
+	defer agent.mutex.Unlock()
+
+	if agent.state != nil {
+		agent.state.Close()
+		agent.state = nil
+	}
+	return nil
+}
+
+// JSON values to Lua: arrays become tables indexed from 1, and null values are
+// left out
+
+func toLua(L *lua.LState, value any) lua.LValue {
+	switch value := value.(type) {
+	case map[string]any:
+		table := L.CreateTable(0, len(value))
+		for key, item := range value {
+			L.SetField(table, key, toLua(L, item))
+		}
+		return table
+	case []any:
+		table := L.CreateTable(len(value), 0)
+		for _, item := range value {
+			table.Append(toLua(L, item))
+		}
+		return table
+	case string:
+		return lua.LString(value)
+	case float64:
+		return lua.LNumber(value)
+	case bool:
+		return lua.LBool(value)
+	}
+	return lua.LNil
+}
+
+// Lua values to JSON: tables with only consecutive integer keys from 1, or
+// empty, become arrays, and other tables objects
+
+func fromLua(value lua.LValue) any {
+	switch value := value.(type) {
+	case *lua.LTable:
+		length := value.Len()
+		count := 0
+		value.ForEach(func(lua.LValue, lua.LValue) { count++ })
+
+		if count == length {
+			array := make([]any, 0, length)
+			for i := 1; i <= length; i++ {
+				array = append(array, fromLua(value.RawGetInt(i)))
+			}
+			return array
+		}
+
+		object := make(map[string]any)
+		value.ForEach(func(key, item lua.LValue) {
+			object[key.String()] = fromLua(item)
+		})
+		return object
+	case lua.LString:
+		return string(value)
+	case lua.LNumber:
+		return float64(value)
+	case lua.LBool:
+		return bool(value)
+	}
+	return nil
+}
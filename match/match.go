@@ -0,0 +1,172 @@
+package match
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	. "hive-arena/common"
+)
+
+// A player of an in-process match
+
+type Seat struct {
+	Name  string
+	Agent Agent
+}
+
+// An agent that returned an error, panicked, or ran out of time. It plays no
+// orders that turn.
+
+type Crash struct {
+	Player int    `json:"player"`
+	Turn   uint   `json:"turn"`
+	Error  string `json:"error"`
+}
+
+type Config struct {
+	Map     string
+	MapData MapData
+	Seed    int64
+	Timing  TurnTiming
+
+	// A position to start from instead of the start of the map, such as the
+	// state of a scenario, and the name of the scenario
+
+	Start    *GameState
+	Scenario string
+}
+
+type Result struct {
+	Game    *PersistedGame
+	Crashes []Crash
+}
+
+// The final state of the game
+
+func (result *Result) Final() *GameState {
+	history := result.Game.History
+	return history[len(history)-1].State
+}
+
+// Plays a whole game in-process, as fast as the agents allow. Agents think in
+// parallel, and turns wait for all of them, so deadlines are up to the agents:
+// the runner package enforces them for agents run as processes. With a time
+// bank, clocks run as on the server, and the orders of an agent out of time
+// are dropped.
+
+func Run(config Config, seats []Seat) (*Result, error) {
+	var state *GameState
+	if config.Start != nil {
+		if config.Start.NumPlayers != len(seats) {
+			return nil, fmt.Errorf("expected %d players, got %d", config.Start.NumPlayers, len(seats))
+		}
+		state = config.Start.Clone()
+	} else {
+		state = NewGameState(config.MapData, len(seats))
+		if state == nil {
+			return nil, fmt.Errorf("invalid number of players: %d", len(seats))
+		}
+	}
+	state.SetSeed(config.Seed)
+	state.Timing = config.Timing
+	state.Clocks = nil
+	if config.Timing.TimeBank > 0 {
+		state.Clocks = make([]Duration, len(seats))
+		for i := range state.Clocks {
+			state.Clocks[i] = config.Timing.TimeBank
+		}
+	}
+
+	players := make([]string, len(seats))
+	for i, seat := range seats {
+		players[i] = seat.Name
+	}
+
+	game := &PersistedGame{
+		Id:          GenerateID(),
+		Map:         config.Map,
+		Scenario:    config.Scenario,
+		CreatedDate: time.Now(),
+		Players:     players,
+		Seed:        config.Seed,
+		Timing:      config.Timing,
+		History:     []Turn{{State: state.Clone()}},
+	}
+	result := &Result{Game: game}
+
+	for !state.GameOver {
+		// The last state of the history doubles as the snapshot agents see,
+		// unless clocks get their increment
+
+		snapshot := game.History[len(game.History)-1].State
+		if state.Clocks != nil {
+			for player := range state.Clocks {
+				state.Clocks[player] += config.Timing.Increment
+			}
+			snapshot = state.Clone()
+		}
+
+		orders, crashes, elapsed := think(snapshot, seats)
+		result.Crashes = append(result.Crashes, crashes...)
+		result.Crashes = append(result.Crashes, chargeClocks(state, orders, elapsed)...)
+
+		processed, err := state.ProcessOrders(orders)
+		if err != nil {
+			return nil, err
+		}
+		game.History = append(game.History, Turn{Orders: processed, State: state.Clone()})
+	}
+
+	game.Stats = ComputeStats(game.History)
+	return result, nil
+}
+
+func think(snapshot *GameState, seats []Seat) ([][]*Order, []Crash, []time.Duration) {
+	orders := make([][]*Order, len(seats))
+	errors := make([]error, len(seats))
+	elapsed := make([]time.Duration, len(seats))
+
+	var group sync.WaitGroup
+	for player, seat := range seats {
+		group.Go(func() {
+			start := time.Now()
+			defer func() {
+				elapsed[player] = time.Since(start)
+				if err := recover(); err != nil {
+					errors[player] = fmt.Errorf("panic: %v", err)
+				}
+			}()
+			orders[player], errors[player] = seat.Agent.Think(snapshot.PlayerView(player), player)
+		})
+	}
+	group.Wait()
+
+	var crashes []Crash
+	for player, err := range errors {
+		if err != nil {
+			orders[player] = nil
+			crashes = append(crashes, Crash{player, snapshot.Turn, err.Error()})
+		}
+	}
+	return orders, crashes, elapsed
+}
+
+// Deducts the thinking time of each player from their clock, and drops the
+// orders of players who ran out of time
+
+func chargeClocks(state *GameState, orders [][]*Order, elapsed []time.Duration) []Crash {
+	if state.Clocks == nil {
+		return nil
+	}
+
+	var crashes []Crash
+	for player, bank := range state.Clocks {
+		if elapsed[player] > max(time.Duration(bank), EMPTY_BANK_WINDOW) && orders[player] != nil {
+			orders[player] = nil
+			crashes = append(crashes, Crash{player, state.Turn, "out of time"})
+		}
+		state.Clocks[player] = max(0, bank-Duration(elapsed[player]))
+	}
+	return crashes
+}
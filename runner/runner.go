@@ -0,0 +1,186 @@
+package runner
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"slices"
+	"sync"
+	"time"
+
+	. "hive-arena/common"
+)
+
+// Longest line an agent can write, in bytes
+const MaxLineLength = 16 << 20
+
+// How long an agent has to exit by itself once the game is over
+const ExitDelay = time.Second
+
+// What the runner writes to the agent's standard input each turn, as a single line
+
+type Request struct {
+	Player int        `json:"player"`
+	State  *GameState `json:"state"`
+}
+
+// An agent running as a child process, or as a WebAssembly module, speaking
+// JSON lines over stdio. Each turn, the runner writes a Request, and the agent
+// answers with a line holding the JSON array of its orders. An agent that
+// misses the deadline is killed, and plays no more orders for the rest of the
+// game.
+
+type Process struct {
+	mutex sync.Mutex
+
+	Timeout time.Duration
+
+	stdin  io.WriteCloser
+	lines  chan []byte
+	done   chan struct{}
+	kill   func()
+	wait   func() error
+	err    error
+	closed bool
+
+	// Called before each turn, and when the agent exits, for the reason it was
+	// stopped, if any
+
+	beginTurn  func()
+	exitReason func() error
+}
+
+func newProcess(stdin io.WriteCloser, stdout io.Reader, timeout time.Duration, kill func(), wait func() error) *Process {
+	process := &Process{
+		Timeout: timeout,
+		stdin:   stdin,
+		lines:   make(chan []byte, 1),
+		done:    make(chan struct{}),
+		kill:    kill,
+		wait:    wait,
+	}
+	go process.read(stdout)
+
+	return process
+}
+
+// Starts an agent. Its standard error is copied to stderr, which can be nil.
+
+func Start(path string, args []string, stderr io.Writer, timeout time.Duration) (*Process, error) {
+	cmd := exec.Command(path, args...)
+	cmd.Stderr = stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start agent %s: %w", path, err)
+	}
+
+	kill := func() { cmd.Process.Kill() }
+	return newProcess(stdin, stdout, timeout, kill, cmd.Wait), nil
+}
+
+func (process *Process) read(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, MaxLineLength)
+
+	defer close(process.lines)
+
+	for scanner.Scan() {
+		select {
+		case process.lines <- slices.Clone(scanner.Bytes()):
+		case <-process.done:
+			return
+		}
+	}
+}
+
+func (process *Process) fail(err error) error {
+	process.err = err
+	process.kill()
+	return err
+}
+
+func (process *Process) Think(view *GameState, player int) ([]*Order, error) {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	if process.err != nil {
+		return nil, process.err
+	}
+	if process.beginTurn != nil {
+		process.beginTurn()
+	}
+
+	// Answers to previous turns that came too late are dropped
+
+	for len(process.lines) > 0 {
+		if _, ok := <-process.lines; !ok {
+			return nil, process.fail(process.exited(fmt.Errorf("agent exited")))
+		}
+	}
+
+	request, err := json.Marshal(Request{player, view})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := process.stdin.Write(append(request, '\n')); err != nil {
+		return nil, process.fail(process.exited(fmt.Errorf("could not write to agent: %w", err)))
+	}
+
+	select {
+	case line, ok := <-process.lines:
+		if !ok {
+			return nil, process.fail(process.exited(fmt.Errorf("agent exited")))
+		}
+
+		var orders []*Order
+		if err := json.Unmarshal(line, &orders); err != nil {
+			return nil, process.fail(fmt.Errorf("invalid orders from agent: %w", err))
+		}
+		return orders, nil
+
+	case <-time.After(process.Timeout):
+		return nil, process.fail(fmt.Errorf("agent timed out after %s", process.Timeout))
+	}
+}
+
+func (process *Process) exited(err error) error {
+	if process.exitReason != nil {
+		if reason := process.exitReason(); reason != nil {
+			return reason
+		}
+	}
+	return err
+}
+
+// Closes the agent's input, and kills it if it does not exit by itself
+
+func (process *Process) Close() error {
+	process.mutex.Lock()
+	defer process.mutex.Unlock()
+
+	if process.closed {
+		return nil
+	}
+	process.closed = true
+	close(process.done)
+	if process.err == nil {
+		process.err = fmt.Errorf("agent closed")
+	}
+
+	process.stdin.Close()
+	timer := time.AfterFunc(ExitDelay, process.kill)
+	defer timer.Stop()
+
+	return process.wait()
+}
@@ -0,0 +1,164 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// Size of a WebAssembly memory page, and most pages a module can have
+const WasmPageSize = 64 << 10
+const MaxWasmPages = 1 << 16
+
+const DefaultWasmMemory = 256 << 20
+
+// Function calls a module can make in a turn by default, enough for agents
+// several times as busy as the built-in bots
+
+const DefaultWasmCalls = 100_000_000
+
+// Modules are compiled once, and reused by all the games they play
+
+var wasmCache = wazero.NewCompilationCache()
+
+func newWasmRuntime(ctx context.Context, memoryLimit uint64) wazero.Runtime {
+	pages := uint32(min(max(memoryLimit/WasmPageSize, 1), MaxWasmPages))
+	config := wazero.NewRuntimeConfig().
+		WithCompilationCache(wasmCache).
+		WithMemoryLimitPages(pages).
+		WithCloseOnContextDone(true)
+	return wazero.NewRuntimeWithConfig(ctx, config)
+}
+
+// Modules compiled with a call limit count their function calls in a meter,
+// found in the context of the running instance. Compiled modules are shared
+// between instances, so the listener itself holds no state.
+
+type callMeter struct {
+	limit uint64
+	calls atomic.Uint64
+}
+
+type callMeterKey struct{}
+
+var errCallLimit = errors.New("call limit reached")
+
+type callListener struct{}
+
+func (callListener) NewFunctionListener(api.FunctionDefinition) experimental.FunctionListener {
+	return callListener{}
+}
+
+func (callListener) Before(ctx context.Context, _ api.Module, _ api.FunctionDefinition, _ []uint64, _ experimental.StackIterator) {
+	meter, _ := ctx.Value(callMeterKey{}).(*callMeter)
+	if meter != nil && meter.calls.Add(1) > meter.limit {
+		panic(errCallLimit)
+	}
+}
+
+func (callListener) After(context.Context, api.Module, api.FunctionDefinition, []uint64) {}
+
+func (callListener) Abort(context.Context, api.Module, api.FunctionDefinition, error) {}
+
+// Checks that a module compiles, and fits in the memory limit
+
+func CompileWasm(module []byte, memoryLimit uint64) error {
+	ctx := context.Background()
+	runtime := newWasmRuntime(ctx, memoryLimit)
+	defer runtime.Close(ctx)
+
+	_, err := runtime.CompileModule(ctx, module)
+	return err
+}
+
+// Starts an agent compiled to WebAssembly for WASI (such as GOOS=wasip1), which
+// speaks the same protocol as processes over its standard input and output.
+// The module has no access to files, the network or the environment, and its
+// memory is limited to memoryLimit bytes. Unless callLimit is 0, the module is
+// stopped once it makes more than callLimit function calls in a turn. Its
+// standard error is copied to stderr, which can be nil.
+
+func StartWasm(module []byte, stderr io.Writer, timeout time.Duration, memoryLimit uint64, callLimit uint64) (*Process, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	runtime := newWasmRuntime(ctx, memoryLimit)
+
+	compileCtx := ctx
+	meter := &callMeter{limit: callLimit}
+	if callLimit > 0 {
+		compileCtx = experimental.WithFunctionListenerFactory(ctx, callListener{})
+		ctx = context.WithValue(ctx, callMeterKey{}, meter)
+	}
+
+	compiled, err := runtime.CompileModule(compileCtx, module)
+	if err != nil {
+		cancel()
+		runtime.Close(context.Background())
+		return nil, fmt.Errorf("could not compile agent: %w", err)
+	}
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+
+	config := wazero.NewModuleConfig().
+		WithName("agent").
+		WithArgs("agent").
+		WithStdin(stdinReader).
+		WithStdout(stdoutWriter).
+		WithStderr(stderr).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader)
+	if stderr == nil {
+		config = config.WithStderr(io.Discard)
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		_, err := runtime.InstantiateModule(ctx, compiled, config)
+		stdinReader.Close()
+		stdoutWriter.Close()
+		runtime.Close(context.Background())
+
+		var exit *sys.ExitError
+		if errors.As(err, &exit) && exit.ExitCode() == 0 {
+			err = nil
+		}
+		exited <- err
+	}()
+
+	// Stopping the module also unblocks it when it waits on its input or output
+
+	kill := func() {
+		cancel()
+		stdinReader.Close()
+		stdoutReader.Close()
+	}
+	wait := func() error {
+		err := <-exited
+		exited <- err
+		return err
+	}
+
+	process := newProcess(stdinWriter, stdoutReader, timeout, kill, wait)
+	if callLimit > 0 {
+		process.beginTurn = func() { meter.calls.Store(0) }
+		process.exitReason = func() error {
+			if meter.calls.Load() > callLimit {
+				return fmt.Errorf("agent made more than %d function calls in a turn", callLimit)
+			}
+			return nil
+		}
+	}
+	return process, nil
+}
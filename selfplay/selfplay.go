@@ -0,0 +1,233 @@
+package selfplay
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"hive-arena/bots"
+	. "hive-arena/common"
+	"hive-arena/match"
+)
+
+// A batch of games between built-in bots. Game i is played on the i-th map in
+// name order, cycling, and seeded with Seed + i. The bot of seat j is seeded
+// with the game seed + j, like in 'arena match', so that any game can be
+// replayed there.
+
+type Config struct {
+	Maps    map[string]MapData
+	Bots    []string
+	Games   int
+	Workers int
+	Seed    int64
+
+	// Shift the bots by one seat from one game to the next
+
+	Rotate bool
+
+	// Keep every state of every game, which is slow, or only the orders
+
+	History bool
+	Trace   bool
+}
+
+type Result struct {
+	Index     int           `json:"index"`
+	Map       string        `json:"map"`
+	Seed      int64         `json:"seed"`
+	Players   []string      `json:"players"`
+	Turns     uint          `json:"turns"`
+	EndReason EndReason     `json:"endReason"`
+	Ranking   []Rank        `json:"ranking"`
+	Crashes   []match.Crash `json:"crashes,omitempty"`
+	Duration  Duration      `json:"duration"`
+
+	// The orders given by each player on each turn. Along with the map and the
+	// seed, they are enough to replay the game.
+
+	Trace [][][]*Order `json:"trace,omitempty"`
+
+	// The whole game, with History
+
+	Game *PersistedGame `json:"-"`
+}
+
+func (config *Config) validate() error {
+	if len(config.Maps) == 0 {
+		return fmt.Errorf("no maps")
+	}
+	if !IsValidNumPlayers(len(config.Bots)) {
+		return fmt.Errorf("invalid number of players: %d", len(config.Bots))
+	}
+	for _, name := range config.Bots {
+		if !slices.Contains(bots.Names(), name) {
+			return fmt.Errorf("unknown bot: %s", name)
+		}
+	}
+	return nil
+}
+
+// Plays the games on a pool of workers, one game per worker at a time. Results
+// are sent as games finish, in any order, and the channel is closed once all
+// games are done, or the context is cancelled.
+
+func Run(ctx context.Context, config Config) (<-chan Result, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	workers := config.Workers
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	names := slices.Sorted(maps.Keys(config.Maps))
+	indexes := make(chan int)
+	results := make(chan Result, workers)
+
+	var group sync.WaitGroup
+	for range workers {
+		group.Go(func() {
+			for index := range indexes {
+				mapname := names[index%len(names)]
+				result := play(&config, index, mapname, config.Maps[mapname])
+				select {
+				case results <- result:
+				case <-ctx.Done():
+				}
+			}
+		})
+	}
+
+	go func() {
+	loop:
+		for index := range config.Games {
+			select {
+			case indexes <- index:
+			case <-ctx.Done():
+				break loop
+			}
+		}
+		close(indexes)
+		group.Wait()
+		close(results)
+	}()
+
+	return results, nil
+}
+
+func play(config *Config, index int, mapname string, mapdata MapData) Result {
+	start := time.Now()
+	seed := config.Seed + int64(index)
+
+	players := slices.Clone(config.Bots)
+	if config.Rotate {
+		shift := index % len(players)
+		players = append(players[shift:], players[:shift]...)
+	}
+
+	agents := make([]Agent, len(players))
+	for seat, name := range players {
+		agents[seat], _ = bots.NewSeeded(name, seed+int64(seat))
+	}
+
+	state := NewGameState(mapdata, len(players))
+	state.SetSeed(seed)
+
+	result := Result{Index: index, Map: mapname, Seed: seed, Players: players}
+
+	var game *PersistedGame
+	if config.History {
+		game = &PersistedGame{
+			Id:          GenerateID(),
+			Map:         mapname,
+			CreatedDate: start,
+			Players:     players,
+			Seed:        seed,
+			History:     []Turn{{State: state.Clone()}},
+		}
+	}
+
+	// Agents think one after the other, on views of the live state: nothing
+	// is copied unless the history is kept
+
+	for !state.GameOver {
+		orders := make([][]*Order, len(agents))
+		for player, agent := range agents {
+			var err error
+			orders[player], err = think(agent, state.PlayerView(player), player)
+			if err != nil {
+				orders[player] = nil
+				result.Crashes = append(result.Crashes, match.Crash{Player: player, Turn: state.Turn, Error: err.Error()})
+			}
+		}
+
+		if config.Trace {
+			result.Trace = append(result.Trace, orders)
+		}
+
+		processed, _ := state.ProcessOrders(orders)
+		if game != nil {
+			game.History = append(game.History, Turn{Orders: processed, State: state.Clone()})
+		}
+	}
+
+	if game != nil {
+		game.Stats = ComputeStats(game.History)
+		result.Game = game
+	}
+
+	result.Turns = state.Turn
+	result.EndReason = state.EndReason
+	result.Ranking = state.Ranking
+	result.Duration = Duration(time.Since(start))
+	return result
+}
+
+func think(agent Agent, view *GameState, player int) (orders []*Order, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	return agent.Think(view, player)
+}
+
+// Rebuilds the whole game from a result with a trace
+
+func Replay(result Result, mapdata MapData) (*PersistedGame, error) {
+	if result.Trace == nil {
+		return nil, fmt.Errorf("no trace")
+	}
+
+	state := NewGameState(mapdata, len(result.Players))
+	if state == nil {
+		return nil, fmt.Errorf("invalid number of players: %d", len(result.Players))
+	}
+	state.SetSeed(result.Seed)
+
+	game := &PersistedGame{
+		Id:          GenerateID(),
+		Map:         result.Map,
+		CreatedDate: time.Now(),
+		Players:     result.Players,
+		Seed:        result.Seed,
+		History:     []Turn{{State: state.Clone()}},
+	}
+
+	for _, orders := range result.Trace {
+		processed, err := state.ProcessOrders(orders)
+		if err != nil {
+			return nil, err
+		}
+		game.History = append(game.History, Turn{Orders: processed, State: state.Clone()})
+	}
+
+	game.Stats = ComputeStats(game.History)
+	return game, nil
+}
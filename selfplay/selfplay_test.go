@@ -0,0 +1,56 @@
+package selfplay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "hive-arena/common"
+)
+
+// Throughput of a single worker playing full games on the balanced map, for
+// instance with 'go test -bench . ./selfplay'
+
+func BenchmarkRun(b *testing.B) {
+	mapdata, err := LoadMap("../maps/balanced.txt")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		history bool
+		trace   bool
+	}{
+		{"plain", false, false},
+		{"trace", false, true},
+		{"history", true, false},
+	}
+
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			config := Config{
+				Maps:    map[string]MapData{"balanced": mapdata},
+				Bots:    []string{"forager", "raider"},
+				Games:   b.N,
+				Workers: 1,
+				Seed:    1,
+				History: c.history,
+				Trace:   c.trace,
+			}
+
+			start := time.Now()
+			results, err := Run(context.Background(), config)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for result := range results {
+				if len(result.Crashes) > 0 {
+					b.Fatalf("bot crashed in game %d", result.Index)
+				}
+			}
+
+			b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "games/s")
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"hive-arena/bots"
+	. "hive-arena/common"
+	"hive-arena/luaagent"
+	"hive-arena/runner"
+)
+
+const LogDir = "logs"
+
+// Bot seats named "exec:<name>" run the executable <name> from the agent
+// directory, speaking JSON lines over stdio
+
+const ExecPrefix = "exec:"
+
+func execPath(name string) (string, bool) {
+	name, found := strings.CutPrefix(name, ExecPrefix)
+	if !found || AgentDir == "" || name == "" || name != filepath.Base(name) {
+		return "", false
+	}
+
+	path := filepath.Join(AgentDir, name)
+	info, err := os.Stat(path)
+	return path, err == nil && !info.IsDir()
+}
+
+// Bot seats named "wasm:<agent name>" run the WebAssembly module uploaded by a
+// registered agent, which plays under its identity
+
+const WasmPrefix = "wasm:"
+
+// Largest module that can be uploaded, in bytes
+const MaxWasmSize = 32 << 20
+
+func wasmPath(agentID string) string {
+	return filepath.Join(WasmDir, agentID+".wasm")
+}
+
+func wasmAgent(registry *Registry, name string) (RegisteredAgent, bool) {
+	name, found := strings.CutPrefix(name, WasmPrefix)
+	if !found || registry == nil {
+		return RegisteredAgent{}, false
+	}
+
+	agent, found := registry.Lookup(name)
+	if !found || agent.Revoked {
+		return RegisteredAgent{}, false
+	}
+
+	info, err := os.Stat(wasmPath(agent.ID))
+	return agent, err == nil && !info.IsDir()
+}
+
+// Saves a module, replacing the previous one of the agent
+
+func saveModule(agentID string, module []byte) error {
+	if err := os.MkdirAll(WasmDir, 0755); err != nil {
+		return err
+	}
+
+	tmp := wasmPath(agentID) + ".tmp"
+	if err := os.WriteFile(tmp, module, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, wasmPath(agentID))
+}
+
+// Bot seats named "lua:<agent name>" run the Lua scripts uploaded by a
+// registered agent in the server, starting from main.lua
+
+const LuaPrefix = "lua:"
+
+// Largest total size of the scripts of an agent, in bytes
+const MaxLuaSize = 1 << 20
+
+func luaPath(agentID string) string {
+	return filepath.Join(LuaDir, agentID)
+}
+
+func luaAgent(registry *Registry, name string) (RegisteredAgent, bool) {
+	name, found := strings.CutPrefix(name, LuaPrefix)
+	if !found || registry == nil {
+		return RegisteredAgent{}, false
+	}
+
+	agent, found := registry.Lookup(name)
+	if !found || agent.Revoked {
+		return RegisteredAgent{}, false
+	}
+
+	info, err := os.Stat(filepath.Join(luaPath(agent.ID), "main.lua"))
+	return agent, err == nil && !info.IsDir()
+}
+
+// Saves the scripts of an agent, once they load, replacing all the previous
+// ones at once
+
+func saveScripts(agentID string, scripts map[string][]byte) error {
+	if _, found := scripts["main.lua"]; !found {
+		return errors.New("missing main.lua")
+	}
+
+	dir := luaPath(agentID)
+	tmp := dir + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return err
+	}
+	for name, source := range scripts {
+		if err := os.WriteFile(filepath.Join(tmp, name), source, 0644); err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+	}
+
+	agent, err := luaagent.Load(filepath.Join(tmp, "main.lua"), nil, Bounds.MaxTurnTimeout)
+	if err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("could not load main.lua: %w", err)
+	}
+	agent.Close()
+
+	old := dir + ".old"
+	os.RemoveAll(old)
+	if err := os.Rename(dir, old); err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+func isValidBot(registry *Registry, name string) bool {
+	_, isExec := execPath(name)
+	_, isWasm := wasmAgent(registry, name)
+	_, isLua := luaAgent(registry, name)
+	return isExec || isWasm || isLua || slices.Contains(bots.Names(), name)
+}
+
+// An agent run by the server, along with the log file of its output
+
+type execAgent struct {
+	Agent
+	log *os.File
+}
+
+func (agent execAgent) Close() error {
+	var err error
+	if closer, ok := agent.Agent.(io.Closer); ok {
+		err = closer.Close()
+	}
+	agent.log.Close()
+	return err
+}
+
+// Creates the agent of a bot seat, and the name and registered agent ID under
+// which it plays
+
+func newBot(session *GameSession, seat int, name string) (Agent, string, string, error) {
+	path, isExec := execPath(name)
+	agent, isWasm := wasmAgent(session.Registry, name)
+	isLua := false
+	if !isWasm {
+		agent, isLua = luaAgent(session.Registry, name)
+	}
+	if !isExec && !isWasm && !isLua {
+		bot, err := bots.New(name)
+		return bot, name + " (bot)", "", err
+	}
+
+	name = strings.TrimPrefix(name, ExecPrefix)
+	if isWasm || isLua {
+		name = agent.Name
+	}
+
+	if err := os.MkdirAll(LogDir, 0755); err != nil {
+		return nil, "", "", err
+	}
+	logfile, err := os.Create(fmt.Sprintf("%s/%s-%d-%s.log", LogDir, session.ID, seat, filepath.Base(name)))
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	// Agents get the whole time bank in chess clock mode, as the clock only
+	// runs on the server
+
+	timeout := time.Duration(session.State.Timing.TurnTimeout)
+	if session.State.Timing.TimeBank > 0 {
+		timeout = time.Duration(session.State.Timing.TimeBank)
+	}
+
+	var bot Agent
+	switch {
+	case isWasm:
+		var module []byte
+		module, err = os.ReadFile(wasmPath(agent.ID))
+		if err == nil {
+			bot, err = runner.StartWasm(module, logfile, timeout, uint64(WasmMemory)<<20, WasmCalls)
+		}
+	case isLua:
+		bot, err = luaagent.Load(filepath.Join(luaPath(agent.ID), "main.lua"), logfile, timeout)
+	default:
+		bot, err = runner.Start(path, nil, logfile, timeout)
+	}
+	if err != nil {
+		logfile.Close()
+		return nil, "", "", err
+	}
+
+	log.Printf("Started agent %s for game %s", name, session.ID)
+
+	return execAgent{bot, logfile}, name, agent.ID, nil
+}
+
+// Stops the bots that run as processes, without waiting for them
+
+func closeBot(bot Agent) {
+	if closer, ok := bot.(io.Closer); ok {
+		go closer.Close()
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"hive-arena/bots"
+	. "hive-arena/common"
+)
+
+// Creates a game from a turn of a finished game, such as to replay it with a
+// patched agent. Each seat is kept for the player that had it: bots run by the
+// server take theirs right away, and agents join as usual, registered agents
+// with their key.
+
+func (server *Server) handleFork(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	query := r.URL.Query()
+
+	id := query.Get("history")
+	path, found := server.findHistory(id)
+	if !found {
+		writeJson(w, "Invalid game id: "+id, http.StatusNotFound)
+		return
+	}
+
+	original, err := LoadPersistedGame(path)
+	if err != nil {
+		writeJson(w, "Could not load game: "+id, http.StatusInternalServerError)
+		return
+	}
+
+	turn := query.Get("turn")
+	state, found := stateAt(original.History, turn)
+	if !found {
+		writeJson(w, "Invalid turn: "+turn, http.StatusBadRequest)
+		return
+	}
+	if state.GameOver {
+		writeJson(w, "Invalid turn: the game is over on turn "+strconv.FormatUint(uint64(state.Turn), 10), http.StatusBadRequest)
+		return
+	}
+	state = state.Clone()
+
+	var seed int64
+	if str := query.Get("seed"); str != "" {
+		if seed, err = strconv.ParseInt(str, 10, 64); err != nil {
+			writeJson(w, "Invalid seed: "+str, http.StatusBadRequest)
+			return
+		}
+		state.SetSeed(seed)
+	}
+
+	seats, err := server.forkSeats(original)
+	if err != nil {
+		writeJson(w, "Invalid fork: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	timing := Bounds.Clamp(original.Timing)
+	practice := original.Practice || query.Get("practice") == "true"
+
+	game := server.createGame(original.Map, state, timing, func(game *GameSession) {
+		game.Scenario = original.Scenario
+		game.ForkedFrom = &ForkOrigin{Game: original.Id, Turn: state.Turn}
+		game.Seed = seed
+		game.Practice = practice
+		game.Reserved = make([]Player, len(original.Players))
+		for seat, name := range original.Players {
+			game.Reserved[seat] = Player{ID: seat, Name: name}
+			if seat < len(original.AgentIds) {
+				game.Reserved[seat].AgentID = original.AgentIds[seat]
+			}
+		}
+	})
+
+	game.mutex.Lock()
+	for seat, bot := range seats {
+		if bot == "" {
+			continue
+		}
+		if _, err := game.addBot(bot); err != nil {
+			game.mutex.Unlock()
+			server.removeGame(game.ID)
+			writeJson(w, "Could not start "+bot+" in seat "+strconv.Itoa(seat)+": "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	game.mutex.Unlock()
+
+	log.Printf("Forked game %s from game %s, turn %d", game.ID, original.Id, state.Turn)
+
+	writeJson(w, map[string]any{
+		"id":          game.ID,
+		"numPlayers":  game.State.NumPlayers,
+		"map":         game.Map,
+		"forkedFrom":  game.ForkedFrom,
+		"seats":       original.Players,
+		"createdDate": game.CreatedDate,
+		"timing":      game.State.Timing,
+		"adminToken":  game.AdminToken,
+	}, http.StatusOK)
+}
+
+// The bot to start in each seat of a fork, or "" for agents that join. Games
+// saved before seat bots were recorded only give back built-in bots, found by
+// name. Uploaded agents run under their current name.
+
+func (server *Server) forkSeats(original *PersistedGame) ([]string, error) {
+	seats := make([]string, len(original.Players))
+
+	for seat, name := range original.Players {
+		if seat < len(original.SeatBots) {
+			seats[seat] = original.SeatBots[seat]
+		} else if bot, isBot := strings.CutSuffix(name, " (bot)"); isBot && slices.Contains(bots.Names(), bot) {
+			seats[seat] = bot
+		}
+
+		bot := seats[seat]
+		if bot == "" {
+			continue
+		}
+
+		for _, prefix := range []string{WasmPrefix, LuaPrefix} {
+			if !strings.HasPrefix(bot, prefix) || seat >= len(original.AgentIds) {
+				continue
+			}
+			if current, found := server.Registry.Name(original.AgentIds[seat]); found {
+				seats[seat] = prefix + current
+			}
+		}
+
+		if !isValidBot(server.Registry, seats[seat]) {
+			return nil, fmt.Errorf("seat %d was played by %s, which cannot be started anymore", seat, bot)
+		}
+	}
+	return seats, nil
+}
@@ -6,23 +6,21 @@ import (
 	"log"
 	"maps"
 	"math/rand"
-	"os"
 	"slices"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 
+	"hive-arena/bots"
 	. "hive-arena/common"
 )
 
-const MinTurnDuration = 500 * time.Millisecond
-const TurnTimeout = 2 * time.Second
-
 type Player struct {
-	ID    int
-	Name  string
-	Token string
+	ID      int
+	Name    string
+	AgentID string
+	Token   string
 }
 
 type GameSession struct {
@@ -30,6 +28,7 @@ type GameSession struct {
 
 	ID           string
 	Map          string
+	Scenario     string
 	CreatedDate  time.Time
 	AdminToken   string
 	PlayerTokens []string
@@ -39,7 +38,53 @@ type GameSession struct {
 	PendingOrders [][]*Order
 	History       []Turn
 
+	Paused bool
+
+	// Practice games can be edited with the admin token, and are not rated
+
+	Practice bool
+
+	// Built-in bots playing some of the seats, and the bot that fills the
+	// seats still empty when the game start times out, if any
+
+	Bots    map[int]Agent
+	FillBot string
+
+	// The bots seats were created with, such as greedy or exec:<file>
+
+	seatBots map[int]string
+
+	// Consecutive turns missed by each player, players whose turns are not
+	// waited for anymore, and events not yet recorded in the history
+
+	missed   []int
+	inactive map[int]bool
+	events   []Event
+
+	turnStart time.Time
+	pausedAt  time.Time
+	timerGen  int
+
 	Sockets []*websocket.Conn
+
+	// Called once the finished game has been saved to the history
+
+	OnPersist func(path string, game *PersistedGame)
+
+	// Registered agents, whose uploaded modules can play as bots
+
+	Registry *Registry
+
+	// In forked games, the game and turn forked from, and the players each
+	// seat is kept for, by agent ID or by name for unregistered agents
+
+	ForkedFrom *ForkOrigin
+	Seed       int64
+	Reserved   []Player
+
+	// Players who took a reserved seat before the seats ahead of theirs
+
+	early map[int]Player
 }
 
 func generateTokens(count int) []string {
@@ -52,10 +97,20 @@ func generateTokens(count int) []string {
 	return slices.Collect(maps.Keys(tokens))
 }
 
-func NewGameSession(id string, players int, mapname string, mapdata MapData) *GameSession {
+// Creates a session playing from the given state, usually the start of a map
 
+func NewGameSession(id string, mapname string, state *GameState, timing TurnTiming) *GameSession {
+
+	players := state.NumPlayers
 	tokens := generateTokens(players + 1)
-	state := NewGameState(mapdata, players)
+	state.Timing = timing
+
+	if timing.TimeBank > 0 {
+		state.Clocks = make([]Duration, players)
+		for i := range state.Clocks {
+			state.Clocks[i] = timing.TimeBank
+		}
+	}
 
 	return &GameSession{
 		ID:           id,
@@ -65,6 +120,11 @@ func NewGameSession(id string, players int, mapname string, mapdata MapData) *Ga
 		PlayerTokens: tokens[1:],
 		State:        state,
 		History:      []Turn{{Orders: nil, State: state.Clone()}},
+		Bots:         make(map[int]Agent),
+		seatBots:     make(map[int]string),
+		early:        make(map[int]Player),
+		missed:       make([]int, players),
+		inactive:     make(map[int]bool),
 	}
 }
 
@@ -72,18 +132,88 @@ func (session *GameSession) IsFull() bool {
 	return len(session.Players) == session.State.NumPlayers
 }
 
-func (session *GameSession) AddPlayer(name string) *Player {
+// The agent ID is empty for unregistered agents
+
+func (session *GameSession) AddPlayer(name string, agentID string) *Player {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return session.addPlayer(name, agentID, nil)
+}
+
+func (session *GameSession) AddBot(name string) (*Player, error) {
 	session.mutex.Lock()
 	defer session.mutex.Unlock()
 
+	return session.addBot(name)
+}
+
+func (session *GameSession) addBot(name string) (*Player, error) {
+	if session.IsFull() {
+		return nil, fmt.Errorf("game is full")
+	}
+
+	botName := name
+	bot, name, agentID, err := newBot(session, len(session.Players), name)
+	if err != nil {
+		return nil, err
+	}
+
+	player := session.addPlayer(name, agentID, bot)
+	if player == nil {
+		closeBot(bot)
+		return nil, fmt.Errorf("no seat for %s", name)
+	}
+	session.seatBots[player.ID] = botName
+	return player, nil
+}
+
+// The seat a player takes: the next one, or in forked games, the first free
+// seat kept for it. Returns -1 if there is none.
+
+func (session *GameSession) seatFor(name string, agentID string) int {
+	if session.Reserved == nil {
+		return len(session.Players)
+	}
+
+	for seat, reserved := range session.Reserved {
+		_, taken := session.early[seat]
+		if seat < len(session.Players) || taken {
+			continue
+		}
+		if reserved.AgentID == agentID && (agentID != "" || reserved.Name == name) {
+			return seat
+		}
+	}
+	return -1
+}
+
+func (session *GameSession) addPlayer(name string, agentID string, bot Agent) *Player {
 	if session.IsFull() {
 		return nil
 	}
 
-	id := len(session.Players)
-	player := Player{id, name, session.PlayerTokens[id]}
+	id := session.seatFor(name, agentID)
+	if id < 0 {
+		return nil
+	}
+	player := Player{id, name, agentID, session.PlayerTokens[id]}
 
-	session.Players = append(session.Players, player)
+	if bot != nil {
+		session.Bots[id] = bot
+	}
+
+	// Players are kept in seat order
+
+	session.early[id] = player
+	for {
+		next, found := session.early[len(session.Players)]
+		if !found {
+			break
+		}
+		session.Players = append(session.Players, next)
+		delete(session.early, next.ID)
+	}
 
 	if session.IsFull() {
 		session.BeginTurn()
@@ -117,9 +247,7 @@ func (session *GameSession) GetView(token string) *GameState {
 
 func (session *GameSession) BeginTurn() {
 
-	if !DevMode {
-		time.Sleep(MinTurnDuration)
-	}
+	time.Sleep(time.Duration(session.State.Timing.MinTurnDuration))
 
 	session.notifySockets()
 
@@ -128,27 +256,275 @@ func (session *GameSession) BeginTurn() {
 	}
 
 	session.PendingOrders = make([][]*Order, session.State.NumPlayers)
+	session.turnStart = time.Now()
+	session.pausedAt = session.turnStart
+
+	for player := range session.State.Clocks {
+		session.State.Clocks[player] += session.State.Timing.Increment
+	}
+
+	for _, player := range session.State.Forfeits {
+		session.PendingOrders[player] = []*Order{}
+	}
+	for player := range session.inactive {
+		session.PendingOrders[player] = []*Order{}
+	}
+
+	if !session.Paused {
+		session.scheduleTimeout()
+	}
 
-	currentTurn := session.State.Turn
-	time.AfterFunc(TurnTimeout, func() {
+	session.runBots()
+}
+
+// Bots think in their own goroutines, on a copy of the state, and post their
+// orders like remote agents
+
+func (session *GameSession) runBots() {
+	if len(session.Bots) == 0 {
+		return
+	}
+
+	state := session.State.Clone()
+	for player, bot := range session.Bots {
+		if !state.HasForfeited(player) {
+			go session.runBot(bot, state.PlayerView(player), player)
+		}
+	}
+}
+
+func (session *GameSession) runBot(bot Agent, view *GameState, playerid int) {
+	orders, ok := session.think(bot, view, playerid)
+	if !ok {
+		return
+	}
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	// Orders for a turn that has already been processed are dropped
+
+	if session.State.Turn != view.Turn || session.State.GameOver || session.Bots[playerid] != bot {
+		return
+	}
+	session.setOrders(playerid, orders)
+}
+
+// A bot that fails misses the turn, like a remote agent that does not answer
+
+func (session *GameSession) think(bot Agent, view *GameState, playerid int) (orders []*Order, ok bool) {
+	name := session.Players[playerid].Name
+
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Bot %s crashed in game %s: %v", name, session.ID, err)
+			orders, ok = nil, false
+		}
+	}()
+
+	orders, err := bot.Think(view, playerid)
+	if err != nil {
+		log.Printf("Bot %s failed in game %s: %s", name, session.ID, err)
+		return nil, false
+	}
+	return orders, true
+}
+
+func (session *GameSession) closeBots() {
+	for _, bot := range session.Bots {
+		closeBot(bot)
+	}
+}
+
+// Stops the bots of a game that will not be played
+
+func (session *GameSession) Shutdown() {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	session.closeBots()
+}
+
+// Fills all empty seats with bots, which starts the game
+
+func (session *GameSession) FillWithBots(name string) error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	for !session.IsFull() {
+		if _, err := session.addBot(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The session's clock stands still while the game is paused
+
+func (session *GameSession) now() time.Time {
+	if session.Paused {
+		return session.pausedAt
+	}
+	return time.Now()
+}
+
+// When a player's orders are due for the current turn
+
+func (session *GameSession) deadline(playerid int) time.Time {
+	if session.State.Clocks == nil {
+		return session.turnStart.Add(time.Duration(session.State.Timing.TurnTimeout))
+	}
+
+	bank := time.Duration(session.State.Clocks[playerid])
+	return session.turnStart.Add(max(bank, EMPTY_BANK_WINDOW))
+}
+
+func (session *GameSession) scheduleTimeout() {
+	var next time.Time
+	for player, orders := range session.PendingOrders {
+		deadline := session.deadline(player)
+		if orders == nil && (next.IsZero() || deadline.Before(next)) {
+			next = deadline
+		}
+	}
+
+	session.timerGen++
+	generation := session.timerGen
+	time.AfterFunc(time.Until(next), func() {
 		session.mutex.Lock()
 		defer session.mutex.Unlock()
 
-		if session.State.Turn == currentTurn {
-			session.processTurn()
+		if session.timerGen == generation && !session.Paused && !session.State.GameOver {
+			session.timeout()
+		}
+	})
+}
+
+// Players whose deadline has passed play no orders this turn
+
+func (session *GameSession) timeout() {
+	now := session.now()
+	for player, orders := range session.PendingOrders {
+		if orders == nil && !now.Before(session.deadline(player)) {
+			session.PendingOrders[player] = []*Order{}
+			session.chargeClock(player, now)
+			session.missTurn(player)
 		}
+	}
+
+	if session.allPlayed() {
+		session.processTurn()
+	} else {
+		session.scheduleTimeout()
+	}
+}
+
+func (session *GameSession) addEvent(kind EventType, playerid int, detail string) {
+	session.events = append(session.events, Event{
+		Type:   kind,
+		Player: playerid,
+		Turn:   session.State.Turn,
+		Detail: detail,
 	})
 }
 
+// After too many consecutive missed turns, a player's seat is handed to the
+// takeover bot, or turns stop waiting for the player
+
+func (session *GameSession) missTurn(playerid int) {
+	session.missed[playerid]++
+
+	if MaxMissedTurns == 0 || session.missed[playerid] < MaxMissedTurns {
+		return
+	}
+	if session.inactive[playerid] {
+		return
+	}
+
+	name := session.Players[playerid].Name
+
+	// Bots can be taken over too, for instance agents run as processes that
+	// crashed, and the takeover bot itself is replaced if it keeps failing. If
+	// the takeover bot cannot start, the player is marked inactive instead.
+
+	var bot Agent
+	if TakeoverBot != "" {
+		var err error
+		if bot, err = bots.New(TakeoverBot); err != nil {
+			log.Printf("Could not start bot %s for player %s in game %s: %v", TakeoverBot, name, session.ID, err)
+		}
+	}
+
+	if bot != nil {
+		if previous := session.Bots[playerid]; previous != nil {
+			closeBot(previous)
+		}
+		session.Bots[playerid] = bot
+		session.missed[playerid] = 0
+		session.addEvent(BOT_TAKEOVER, playerid, TakeoverBot)
+		log.Printf("Bot %s took over player %s in game %s", TakeoverBot, name, session.ID)
+	} else {
+		session.inactive[playerid] = true
+		session.addEvent(INACTIVE, playerid, "")
+		log.Printf("Player %s is inactive in game %s", name, session.ID)
+	}
+}
+
+func (session *GameSession) chargeClock(playerid int, now time.Time) {
+	if session.State.Clocks == nil {
+		return
+	}
+
+	elapsed := Duration(now.Sub(session.turnStart))
+	session.State.Clocks[playerid] = max(0, session.State.Clocks[playerid]-elapsed)
+}
+
 func (session *GameSession) SetOrders(playerid int, orders []*Order) {
 	session.mutex.Lock()
 	defer session.mutex.Unlock()
 
+	// A player who comes back takes their seat back
+
+	if session.PendingOrders != nil && !session.State.HasForfeited(playerid) {
+		if session.inactive[playerid] || session.Bots[playerid] != nil {
+			delete(session.inactive, playerid)
+			if bot := session.Bots[playerid]; bot != nil {
+				closeBot(bot)
+				delete(session.Bots, playerid)
+			}
+			session.addEvent(RECONNECTED, playerid, "")
+			log.Printf("Player %s is back in game %s", session.Players[playerid].Name, session.ID)
+		}
+	}
+
+	session.setOrders(playerid, orders)
+}
+
+func (session *GameSession) setOrders(playerid int, orders []*Order) {
+	if session.State.HasForfeited(playerid) {
+		log.Printf("Player %s posted orders after forfeiting game %s", session.Players[playerid].Name, session.ID)
+		return
+	}
+
+	now := session.now()
+	if now.After(session.deadline(playerid)) {
+		log.Printf("Player %s posted orders too late in game %s", session.Players[playerid].Name, session.ID)
+		return
+	}
+	session.missed[playerid] = 0
+
+	if session.PendingOrders[playerid] == nil {
+		session.chargeClock(playerid, now)
+	}
+
+	if orders == nil {
+		orders = []*Order{}
+	}
 	session.PendingOrders[playerid] = orders
 
 	log.Printf("Player %s posted orders in game %s", session.Players[playerid].Name, session.ID)
 
-	if session.allPlayed() {
+	if session.allPlayed() && !session.Paused {
 		session.processTurn()
 	}
 }
@@ -166,16 +542,148 @@ func (session *GameSession) processTurn() {
 	log.Printf("Processing orders for game %s, turn %d", session.ID, session.State.Turn)
 
 	results, _ := session.State.ProcessOrders(session.PendingOrders)
-	session.History = append(session.History, Turn{Orders: results, State: session.State.Clone()})
+	session.History = append(session.History, Turn{Orders: results, State: session.State.Clone(), Events: session.events})
+	session.events = nil
 
 	if session.State.GameOver {
 		log.Printf("Game %s is over", session.ID)
 		session.persist()
+		session.closeBots()
 	}
 
 	session.BeginTurn()
 }
 
+// Admin controls
+
+func (session *GameSession) Pause() error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if session.State.GameOver {
+		return fmt.Errorf("game is over")
+	}
+	if session.Paused {
+		return fmt.Errorf("game is already paused")
+	}
+
+	session.Paused = true
+	session.pausedAt = time.Now()
+	session.timerGen++
+
+	log.Printf("Game %s paused on turn %d", session.ID, session.State.Turn)
+	return nil
+}
+
+func (session *GameSession) Resume() error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if !session.Paused {
+		return fmt.Errorf("game is not paused")
+	}
+
+	// Shift the turn start so that the pause does not count against deadlines
+
+	session.turnStart = session.turnStart.Add(time.Since(session.pausedAt))
+	session.Paused = false
+
+	log.Printf("Game %s resumed on turn %d", session.ID, session.State.Turn)
+
+	if !session.IsFull() {
+		return nil
+	}
+
+	if session.allPlayed() {
+		session.processTurn()
+	} else {
+		session.scheduleTimeout()
+	}
+	return nil
+}
+
+// Processes the current turn of a paused game with the orders received so far
+
+func (session *GameSession) Step() error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if !session.Paused {
+		return fmt.Errorf("game is not paused")
+	}
+	if !session.IsFull() {
+		return fmt.Errorf("game has not started")
+	}
+	if session.State.GameOver {
+		return fmt.Errorf("game is over")
+	}
+
+	for player, orders := range session.PendingOrders {
+		if orders == nil {
+			session.PendingOrders[player] = []*Order{}
+			session.chargeClock(player, session.pausedAt)
+		}
+	}
+
+	log.Printf("Stepping game %s", session.ID)
+	session.processTurn()
+	return nil
+}
+
+func (session *GameSession) Abort() error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if session.State.GameOver {
+		return fmt.Errorf("game is over")
+	}
+
+	session.State.Abort()
+	session.timerGen++
+
+	// The last recorded state becomes the final one
+
+	last := &session.History[len(session.History)-1]
+	last.State = session.State.Clone()
+	last.Events = append(last.Events, session.events...)
+	session.events = nil
+
+	log.Printf("Game %s was aborted", session.ID)
+	session.persist()
+	session.closeBots()
+	session.notifySockets()
+	return nil
+}
+
+func (session *GameSession) Forfeit(playerid int) error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if playerid < 0 || playerid >= len(session.Players) {
+		return fmt.Errorf("invalid player: %d", playerid)
+	}
+	if session.State.GameOver {
+		return fmt.Errorf("game is over")
+	}
+	if session.State.HasForfeited(playerid) {
+		return fmt.Errorf("player %d has already forfeited", playerid)
+	}
+
+	session.State.Forfeit(playerid)
+	session.addEvent(FORFEITED, playerid, "")
+	log.Printf("Player %s forfeited game %s", session.Players[playerid].Name, session.ID)
+
+	if session.PendingOrders == nil {
+		return nil
+	}
+
+	session.PendingOrders[playerid] = []*Order{}
+	if session.allPlayed() && !session.Paused {
+		session.processTurn()
+	}
+	return nil
+}
+
 func (session *GameSession) RegisterWebSocket(socket *websocket.Conn) {
 	session.mutex.Lock()
 	defer session.mutex.Unlock()
@@ -207,14 +715,6 @@ func (session *GameSession) notifySockets() {
 }
 
 func (session *GameSession) persist() {
-	date, _ := session.CreatedDate.MarshalText()
-	path := fmt.Sprintf("%s/%s-%s-%s.json",
-		HistoryDir,
-		date,
-		session.ID,
-		session.Map,
-	)
-
 	players := make([]string, len(session.Players))
 	for i, player := range session.Players {
 		players[i] = player.Name
@@ -223,15 +723,63 @@ func (session *GameSession) persist() {
 	info := PersistedGame{
 		Id:          session.ID,
 		Map:         session.Map,
+		Scenario:    session.Scenario,
 		CreatedDate: session.CreatedDate,
 		Players:     players,
+		AgentIds:    session.agentIds(),
+		SeatBots:    session.seatBotNames(),
+		Seed:        session.Seed,
+		Timing:      session.State.Timing,
+		ForkedFrom:  session.ForkedFrom,
+		Practice:    session.Practice,
 		History:     session.History,
+		Stats:       ComputeStats(session.History),
+	}
+
+	path, err := info.Save(HistoryDir)
+	if err != nil {
+		log.Printf("Could not save game %s: %s", session.ID, err)
+		return
+	}
+
+	if session.OnPersist != nil {
+		session.OnPersist(path, &info)
+	}
+}
+
+func (session *GameSession) Stats() []PlayerStats {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	return ComputeStats(session.History)
+}
+
+// IDs of the registered agents in each seat, or nil if no agent is registered
+
+func (session *GameSession) agentIds() []string {
+	ids := make([]string, len(session.Players))
+	for i, player := range session.Players {
+		ids[i] = player.AgentID
 	}
 
-	file, _ := os.Create(path)
-	defer file.Close()
+	if !slices.ContainsFunc(ids, func(id string) bool { return id != "" }) {
+		return nil
+	}
+	return ids
+}
+
+// The bot each seat was created with, or nil if no seat is a bot
 
-	json.NewEncoder(file).Encode(info)
+func (session *GameSession) seatBotNames() []string {
+	if len(session.seatBots) == 0 {
+		return nil
+	}
+
+	names := make([]string, len(session.Players))
+	for seat, name := range session.seatBots {
+		names[seat] = name
+	}
+	return names
 }
 
 func (session *GameSession) Status() SessionStatus {
@@ -245,8 +793,20 @@ func (session *GameSession) Status() SessionStatus {
 		Id:          session.ID,
 		CreatedDate: session.CreatedDate,
 		Map:         session.Map,
+		Scenario:    session.Scenario,
+		ForkedFrom:  session.ForkedFrom,
+		Practice:    session.Practice,
 		NumPlayers:  session.State.NumPlayers,
 		Players:     players,
+		AgentIds:    session.agentIds(),
+		Bots:        slices.Sorted(maps.Keys(session.Bots)),
+		Inactive:    slices.Sorted(maps.Keys(session.inactive)),
+		MissedTurns: slices.Clone(session.missed),
+		Timing:      session.State.Timing,
+		Paused:      session.Paused,
 		GameOver:    session.State.GameOver,
+		EndReason:   session.State.EndReason,
+		Winners:     session.State.Winners,
+		Ranking:     session.State.Ranking,
 	}
 }
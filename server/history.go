@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	. "hive-arena/common"
+)
+
+type HistoryEntry struct {
+	Path    string
+	Summary GameSummary
+}
+
+// Summaries of all persisted games, sorted from oldest to newest
+
+type HistoryIndex struct {
+	mutex   sync.Mutex
+	Entries []HistoryEntry
+}
+
+func loadHistoryIndex() *HistoryIndex {
+	index := &HistoryIndex{}
+
+	paths, _ := filepath.Glob(HistoryDir + "/*.json")
+	for _, path := range paths {
+		game, err := LoadPersistedGame(path)
+		if err != nil {
+			log.Printf("Skipping history file: %s", err)
+			continue
+		}
+		index.Entries = append(index.Entries, HistoryEntry{path, game.Summary()})
+	}
+
+	slices.SortFunc(index.Entries, func(a, b HistoryEntry) int {
+		return a.Summary.CreatedDate.Compare(b.Summary.CreatedDate)
+	})
+
+	log.Printf("Loaded %d games from history", len(index.Entries))
+
+	return index
+}
+
+func (index *HistoryIndex) Add(path string, game *PersistedGame) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	index.Entries = append(index.Entries, HistoryEntry{path, game.Summary()})
+}
+
+func (index *HistoryIndex) Find(id string) (string, error) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	for _, entry := range index.Entries {
+		if entry.Summary.Id == id {
+			return entry.Path, nil
+		}
+	}
+	return "", fmt.Errorf("game not found in history: %s", id)
+}
+
+func (index *HistoryIndex) Load(id string) (*PersistedGame, error) {
+	path, err := index.Find(id)
+	if err != nil {
+		return nil, err
+	}
+	return LoadPersistedGame(path)
+}
+
+func (index *HistoryIndex) Summaries() []GameSummary {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	summaries := make([]GameSummary, len(index.Entries))
+	for i, entry := range index.Entries {
+		summaries[i] = entry.Summary
+	}
+	return summaries
+}
+
+type HistoryFilter struct {
+	Player string
+	Agent  string
+	Map    string
+	From   time.Time
+	To     time.Time
+}
+
+func (filter HistoryFilter) Matches(summary GameSummary) bool {
+	if filter.Player != "" && !slices.Contains(summary.Players, filter.Player) {
+		return false
+	}
+	if filter.Agent != "" && !slices.Contains(summary.AgentIds, filter.Agent) {
+		return false
+	}
+	if filter.Map != "" && summary.Map != filter.Map {
+		return false
+	}
+	if !filter.From.IsZero() && summary.CreatedDate.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && !summary.CreatedDate.Before(filter.To) {
+		return false
+	}
+	return true
+}
+
+// Returns one page of matching summaries, newest first, and the total number of matches
+
+func (index *HistoryIndex) Query(filter HistoryFilter, offset int, limit int) ([]GameSummary, int) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+
+	summaries := []GameSummary{}
+	total := 0
+
+	for _, entry := range slices.Backward(index.Entries) {
+		if !filter.Matches(entry.Summary) {
+			continue
+		}
+		if total >= offset && len(summaries) < limit {
+			summaries = append(summaries, entry.Summary)
+		}
+		total++
+	}
+
+	return summaries, total
+}
+
+// Dates are either full ISO 8601 timestamps, or days. An end day is included
+// in the range.
+
+func parseDate(str string, endOfDay bool) (time.Time, error) {
+	if str == "" {
+		return time.Time{}, nil
+	}
+
+	if date, err := time.Parse(time.RFC3339, str); err == nil {
+		return date, nil
+	}
+
+	date, err := time.ParseInLocation(time.DateOnly, str, time.Local)
+	if err != nil {
+		return date, fmt.Errorf("invalid date: %s", str)
+	}
+	if endOfDay {
+		date = date.AddDate(0, 0, 1)
+	}
+	return date, nil
+}
+
+// Older history files can still be fetched by file name
+
+func historyFileByName(name string) (string, bool) {
+	if !strings.HasSuffix(name, ".json") {
+		return "", false
+	}
+
+	path := filepath.Join(HistoryDir, filepath.Base(name))
+	_, err := os.Stat(path)
+	return path, err == nil
+}
@@ -3,7 +3,12 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"runtime/debug"
+	"time"
+
+	"hive-arena/bots"
+	"hive-arena/runner"
 )
 
 func GitRevision() string {
@@ -17,13 +22,49 @@ func GitRevision() string {
 	return ""
 }
 
-var DevMode bool
+var Bounds TimingBounds
+var AgentsFile string
+var ServerAdminToken string
+var MaxMissedTurns int
+var TakeoverBot string
+var AgentDir string
+var WasmDir string
+var WasmMemory int
+var WasmCalls uint64
+var LuaDir string
 
 func main() {
 	port := flag.Int("p", 8000, "port on which the server will listen")
-	flag.BoolVar(&DevMode, "dev", false, "run the server in development mode")
+	dev := flag.Bool("dev", false, "run the server in development mode (games have no minimum turn duration by default)")
+	flag.DurationVar(&Bounds.MinTurnDuration, "min-turn-duration", DefaultMinTurnDuration, "lowest minimum turn duration a game can request")
+	flag.DurationVar(&Bounds.MaxTurnDuration, "max-turn-duration", 10*time.Second, "highest minimum turn duration a game can request")
+	flag.DurationVar(&Bounds.MinTurnTimeout, "min-turn-timeout", 100*time.Millisecond, "lowest turn timeout a game can request")
+	flag.DurationVar(&Bounds.MaxTurnTimeout, "max-turn-timeout", 10*time.Second, "highest turn timeout a game can request")
+	flag.DurationVar(&Bounds.MaxTimeBank, "max-time-bank", 10*time.Minute, "largest chess clock time bank a game can request")
+	flag.StringVar(&AgentsFile, "agents", "agents/registry.json", "file in which registered agents are saved")
+	flag.StringVar(&ServerAdminToken, "admin-token", "", "token for the server admin routes (disabled if empty)")
+	flag.IntVar(&MaxMissedTurns, "max-missed-turns", 0, "consecutive turns a player can miss before being taken over (0 to disable)")
+	flag.StringVar(&TakeoverBot, "takeover-bot", "", "built-in bot that takes over players who miss too many turns (if empty, turns stop waiting for them instead)")
+	flag.StringVar(&AgentDir, "agent-dir", "", "directory of agent executables that games can run as bots, with names such as exec:<file>")
+	flag.StringVar(&WasmDir, "wasm-dir", "agents/wasm", "directory in which uploaded WebAssembly agents are saved")
+	flag.IntVar(&WasmMemory, "wasm-memory", 256, "memory limit of WebAssembly agents, in MiB")
+	flag.Uint64Var(&WasmCalls, "wasm-calls", runner.DefaultWasmCalls, "function calls a WebAssembly agent can make each turn (0 for no limit)")
+	flag.StringVar(&LuaDir, "lua-dir", "agents/lua", "directory in which uploaded Lua agents are saved")
 	flag.Parse()
 
+	if _, err := bots.New(TakeoverBot); TakeoverBot != "" && err != nil {
+		log.Fatalf("Invalid takeover bot: %s", err)
+	}
+	if WasmMemory < 1 || WasmMemory > runner.MaxWasmPages*runner.WasmPageSize>>20 {
+		log.Fatalf("Invalid WebAssembly memory limit: %d MiB, must be between 1 and %d", WasmMemory, runner.MaxWasmPages*runner.WasmPageSize>>20)
+	}
+
+	Bounds.DefaultTurnDuration = DefaultMinTurnDuration
+	if *dev {
+		Bounds.MinTurnDuration = 0
+		Bounds.DefaultTurnDuration = 0
+	}
+
 	fmt.Println("git revision: " + GitRevision())
 	RunServer(*port)
 }
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	. "hive-arena/common"
+)
+
+const DefaultQueueTimeout = 60 * time.Second
+const MaxQueueTimeout = 10 * time.Minute
+
+// Agents wait for a game with the same number of players and map. An empty map
+// name means any map.
+
+type QueueKey struct {
+	Players int
+	Map     string
+}
+
+type queuedAgent struct {
+	name    string
+	agentID string
+	channel chan Assignment
+}
+
+func (agent *queuedAgent) sameAgent(other *queuedAgent) bool {
+	if agent.agentID != "" || other.agentID != "" {
+		return agent.agentID == other.agentID
+	}
+	return agent.name == other.name
+}
+
+type Matchmaker struct {
+	mutex   sync.Mutex
+	waiting map[QueueKey][]*queuedAgent
+	server  *Server
+}
+
+func NewMatchmaker(server *Server) *Matchmaker {
+	return &Matchmaker{
+		waiting: make(map[QueueKey][]*queuedAgent),
+		server:  server,
+	}
+}
+
+// Blocks until enough agents are waiting for the same kind of game, then starts
+// it. Returns false if the wait timed out or was cancelled. An agent can only
+// wait once for each kind of game: registered agents are told apart by their
+// ID, others by their name.
+
+func (matchmaker *Matchmaker) Wait(ctx context.Context, name string, agentID string, key QueueKey, timeout time.Duration) (Assignment, bool, error) {
+	agent := &queuedAgent{name, agentID, make(chan Assignment, 1)}
+
+	matchmaker.mutex.Lock()
+	if slices.ContainsFunc(matchmaker.waiting[key], agent.sameAgent) {
+		matchmaker.mutex.Unlock()
+		return Assignment{}, false, fmt.Errorf("agent already queued: %s", name)
+	}
+	matchmaker.waiting[key] = append(matchmaker.waiting[key], agent)
+	agents, mapname := matchmaker.match(key)
+	matchmaker.mutex.Unlock()
+
+	if agents != nil {
+		matchmaker.start(key, agents, mapname)
+	}
+
+	select {
+	case assignment := <-agent.channel:
+		return assignment, true, nil
+	case <-time.After(timeout):
+	case <-ctx.Done():
+	}
+
+	matchmaker.mutex.Lock()
+	queued := slices.Contains(matchmaker.waiting[key], agent)
+	matchmaker.waiting[key] = slices.DeleteFunc(matchmaker.waiting[key], func(other *queuedAgent) bool {
+		return other == agent
+	})
+	matchmaker.mutex.Unlock()
+
+	// The agent may have been matched just before the timeout, and its
+	// assignment is then on its way
+
+	if !queued {
+		return <-agent.channel, true, nil
+	}
+	return Assignment{}, false, nil
+}
+
+// Takes the agents of the next game out of the queue, if there are enough. The
+// game is started by start, once the queue is unlocked.
+
+func (matchmaker *Matchmaker) match(key QueueKey) ([]*queuedAgent, string) {
+	queue := matchmaker.waiting[key]
+	if len(queue) < key.Players {
+		return nil, ""
+	}
+
+	agents := queue[:key.Players]
+	matchmaker.waiting[key] = slices.Clone(queue[key.Players:])
+
+	mapname := key.Map
+	if mapname == "" {
+		names := slices.Sorted(maps.Keys(matchmaker.server.Maps))
+		mapname = names[rand.Intn(len(names))]
+	}
+
+	// Seats are shuffled so that arriving first gives no advantage
+
+	rand.Shuffle(len(agents), func(i, j int) {
+		agents[i], agents[j] = agents[j], agents[i]
+	})
+
+	return agents, mapname
+}
+
+func (matchmaker *Matchmaker) start(key QueueKey, agents []*queuedAgent, mapname string) {
+	session := matchmaker.server.createGame(mapname, NewGameState(matchmaker.server.Maps[mapname], key.Players), Bounds.Default(), nil)
+	for _, agent := range agents {
+		player := session.AddPlayer(agent.name, agent.agentID)
+		agent.channel <- Assignment{session.ID, player.ID, player.Token}
+	}
+
+	log.Printf("Matched %d agents in game %s", key.Players, session.ID)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"cmp"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const MaxAgentNameLength = 64
+
+// A registered agent. Only a hash of its secret key is kept.
+
+type RegisteredAgent struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	KeyHash     string    `json:"keyHash,omitempty"`
+	CreatedDate time.Time `json:"createdDate"`
+	Revoked     bool      `json:"revoked"`
+}
+
+type Registry struct {
+	mutex sync.Mutex
+
+	path   string
+	Agents map[string]*RegisteredAgent
+}
+
+func randomHex(bytes int) string {
+	data := make([]byte, bytes)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
+
+func hashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// Names are compared without case, so that a team cannot be registered twice
+// under different spellings
+
+func sameName(a, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+func LoadRegistry(path string) *Registry {
+	registry := &Registry{path: path, Agents: make(map[string]*RegisteredAgent)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return registry
+	}
+	if err != nil {
+		log.Fatalf("Could not read agent registry %s: %s", path, err)
+	}
+
+	var agents []*RegisteredAgent
+	if err := json.Unmarshal(data, &agents); err != nil {
+		log.Fatalf("Invalid agent registry %s: %s", path, err)
+	}
+	for _, agent := range agents {
+		registry.Agents[agent.ID] = agent
+	}
+
+	log.Printf("Loaded %d registered agents", len(agents))
+
+	return registry
+}
+
+func (registry *Registry) save() error {
+	data, err := json.MarshalIndent(registry.list(), "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// Write then rename, so that a crash never leaves a truncated registry
+
+	if err := os.MkdirAll(filepath.Dir(registry.path), 0755); err != nil {
+		return err
+	}
+
+	tmp := registry.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, registry.path)
+}
+
+func (registry *Registry) list() []*RegisteredAgent {
+	agents := slices.Collect(maps.Values(registry.Agents))
+	slices.SortFunc(agents, func(a, b *RegisteredAgent) int {
+		return cmp.Or(a.CreatedDate.Compare(b.CreatedDate), cmp.Compare(a.ID, b.ID))
+	})
+	return agents
+}
+
+// Public view of all agents, without their key hashes
+
+func (registry *Registry) List() []RegisteredAgent {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	var agents []RegisteredAgent
+	for _, agent := range registry.list() {
+		public := *agent
+		public.KeyHash = ""
+		agents = append(agents, public)
+	}
+	return agents
+}
+
+func (registry *Registry) findName(name string) *RegisteredAgent {
+	for _, agent := range registry.Agents {
+		if sameName(agent.Name, name) {
+			return agent
+		}
+	}
+	return nil
+}
+
+func checkName(name string) error {
+	if strings.TrimSpace(name) == "" || len(name) > MaxAgentNameLength {
+		return fmt.Errorf("invalid name: %q", name)
+	}
+	return nil
+}
+
+// Registers a new agent, and returns it along with its secret key. The key is
+// not stored, and cannot be recovered later.
+
+func (registry *Registry) Register(name string) (RegisteredAgent, string, error) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	name = strings.TrimSpace(name)
+	if err := checkName(name); err != nil {
+		return RegisteredAgent{}, "", err
+	}
+	if registry.findName(name) != nil {
+		return RegisteredAgent{}, "", fmt.Errorf("name already registered: %s", name)
+	}
+
+	id := randomHex(8)
+	for registry.Agents[id] != nil {
+		id = randomHex(8)
+	}
+
+	key := randomHex(24)
+	agent := &RegisteredAgent{
+		ID:          id,
+		Name:        name,
+		KeyHash:     hashKey(key),
+		CreatedDate: time.Now(),
+	}
+
+	registry.Agents[id] = agent
+	if err := registry.save(); err != nil {
+		delete(registry.Agents, id)
+		return RegisteredAgent{}, "", fmt.Errorf("could not save agent registry: %w", err)
+	}
+
+	log.Printf("Registered agent %s (%s)", name, id)
+
+	return *agent, key, nil
+}
+
+// Finds the agent owning a key
+
+func (registry *Registry) Authenticate(key string) (RegisteredAgent, error) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	hash := hashKey(key)
+	for _, agent := range registry.Agents {
+		if agent.KeyHash != hash {
+			continue
+		}
+		if agent.Revoked {
+			return RegisteredAgent{}, fmt.Errorf("agent has been revoked: %s", agent.Name)
+		}
+		return *agent, nil
+	}
+	return RegisteredAgent{}, fmt.Errorf("invalid key")
+}
+
+// Finds the agent registered under a name. Registered names, even those of
+// revoked agents, can only be used with a key.
+
+func (registry *Registry) Lookup(name string) (RegisteredAgent, bool) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	agent := registry.findName(name)
+	if agent == nil {
+		return RegisteredAgent{}, false
+	}
+	return *agent, true
+}
+
+func (registry *Registry) Name(id string) (string, bool) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	agent, found := registry.Agents[id]
+	if !found {
+		return "", false
+	}
+	return agent.Name, true
+}
+
+func (registry *Registry) Revoke(id string) error {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	agent, found := registry.Agents[id]
+	if !found {
+		return fmt.Errorf("invalid agent id: %s", id)
+	}
+
+	agent.Revoked = true
+	if err := registry.save(); err != nil {
+		agent.Revoked = false
+		return fmt.Errorf("could not save agent registry: %w", err)
+	}
+
+	log.Printf("Revoked agent %s (%s)", agent.Name, id)
+	return nil
+}
+
+func (registry *Registry) Rename(id string, name string) error {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	agent, found := registry.Agents[id]
+	if !found {
+		return fmt.Errorf("invalid agent id: %s", id)
+	}
+
+	name = strings.TrimSpace(name)
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if other := registry.findName(name); other != nil && other != agent {
+		return fmt.Errorf("name already registered: %s", name)
+	}
+
+	previous := agent.Name
+	agent.Name = name
+	if err := registry.save(); err != nil {
+		agent.Name = previous
+		return fmt.Errorf("could not save agent registry: %w", err)
+	}
+
+	log.Printf("Renamed agent %s (%s) to %s", previous, id, name)
+	return nil
+}
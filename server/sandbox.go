@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+
+	. "hive-arena/common"
+)
+
+// Practice games can be edited live with their admin token, to set up positions
+// for the agents playing them. Edits are made while the game is paused: the
+// orders given so far for the current turn are discarded, so that stepping
+// plays an empty turn and agents see the edited board on the next one.
+
+func (session *GameSession) checkEditable() error {
+	if !session.Practice {
+		return fmt.Errorf("not a practice game")
+	}
+	if session.State.GameOver {
+		return fmt.Errorf("game is over")
+	}
+	if !session.Paused {
+		return fmt.Errorf("game is not paused")
+	}
+	return nil
+}
+
+func (session *GameSession) edited(playerid int, detail string) {
+	// Players who already played keep empty orders, as their clock was charged
+
+	for player, orders := range session.PendingOrders {
+		if orders != nil {
+			session.PendingOrders[player] = []*Order{}
+		}
+	}
+
+	session.addEvent(EDITED, playerid, detail)
+	log.Printf("Game %s edited on turn %d: %s", session.ID, session.State.Turn, detail)
+	session.notifySockets()
+}
+
+func (session *GameSession) editableHex(coords Coords) (*Hex, error) {
+	if err := session.checkEditable(); err != nil {
+		return nil, err
+	}
+	hex := session.State.Hexes[coords]
+	if hex == nil {
+		return nil, fmt.Errorf("invalid coordinates: %s", coords)
+	}
+	return hex, nil
+}
+
+// Places an entity on a hex, replacing the one already there
+
+func (session *GameSession) Place(coords Coords, entity Entity) error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	hex, err := session.editableHex(coords)
+	if err != nil {
+		return err
+	}
+
+	switch entity.Type {
+	case BEE, HIVE, WALL:
+	default:
+		return fmt.Errorf("invalid entity type: %s", entity.Type)
+	}
+	if entity.Player < 0 || entity.Player >= session.State.NumPlayers {
+		return fmt.Errorf("invalid player: %d", entity.Player)
+	}
+	if !hex.Terrain.IsWalkable() {
+		return fmt.Errorf("%s on %s", entity.Type, hex.Terrain)
+	}
+	if entity.HasFlower && entity.Type != BEE {
+		return fmt.Errorf("%s carrying a flower", entity.Type)
+	}
+
+	hex.Entity = &entity
+	session.edited(entity.Player, fmt.Sprintf("%s placed at %s", entity.Type, coords))
+	return nil
+}
+
+func (session *GameSession) Remove(coords Coords) error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	hex, err := session.editableHex(coords)
+	if err != nil {
+		return err
+	}
+	if hex.Entity == nil {
+		return fmt.Errorf("no entity at %s", coords)
+	}
+
+	entity := hex.Entity
+	hex.Entity = nil
+	session.edited(entity.Player, fmt.Sprintf("%s removed from %s", entity.Type, coords))
+	return nil
+}
+
+// Sets the flowers left on a field
+
+func (session *GameSession) SetFieldFlowers(coords Coords, flowers uint) error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	hex, err := session.editableHex(coords)
+	if err != nil {
+		return err
+	}
+	if hex.Terrain != FIELD {
+		return fmt.Errorf("resources on %s", hex.Terrain)
+	}
+
+	hex.Resources = flowers
+	session.edited(-1, fmt.Sprintf("%d flowers at %s", flowers, coords))
+	return nil
+}
+
+// Sets the flowers a player has delivered
+
+func (session *GameSession) SetPlayerFlowers(playerid int, flowers uint) error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if err := session.checkEditable(); err != nil {
+		return err
+	}
+	if playerid < 0 || playerid >= session.State.NumPlayers {
+		return fmt.Errorf("invalid player: %d", playerid)
+	}
+
+	session.State.PlayerResources[playerid] = flowers
+	session.edited(playerid, fmt.Sprintf("%d flowers delivered", flowers))
+	return nil
+}
+
+// Restores the board of an earlier turn. The turn number keeps counting up, as
+// agents wait for the next turn, and the clocks and forfeits are kept. Seeded
+// games draw from their seed again.
+
+func (session *GameSession) Rewind(turn uint) error {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	if err := session.checkEditable(); err != nil {
+		return err
+	}
+
+	index := slices.IndexFunc(session.History, func(entry Turn) bool { return entry.State.Turn == turn })
+	if index < 0 || turn >= session.State.Turn {
+		return fmt.Errorf("invalid turn: %d", turn)
+	}
+
+	current := session.State
+	state := session.History[index].State.Clone()
+	state.LastResourceChange += current.Turn - turn
+	state.Turn = current.Turn
+	state.Timing = current.Timing
+	state.Clocks = slices.Clone(current.Clocks)
+	state.Forfeits = slices.Clone(current.Forfeits)
+	if session.Seed != 0 {
+		state.SetSeed(session.Seed)
+	}
+
+	session.State = state
+	session.edited(-1, fmt.Sprintf("rewound to turn %d", turn))
+	return nil
+}
+
+// Sandbox routes take the game id and admin token, and the parameters of the
+// edit
+
+func (server *Server) handleSandbox(action func(*GameSession, url.Values) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRoute(r)
+
+		game := server.adminGame(w, r)
+		if game == nil {
+			return
+		}
+
+		writeResult(w, action(game, r.URL.Query()))
+	}
+}
+
+func parseCoords(query url.Values) (Coords, error) {
+	var coords Coords
+	str := query.Get("coords")
+	if err := coords.FromString(str); err != nil {
+		return coords, fmt.Errorf("invalid coordinates: %s", str)
+	}
+	return coords, nil
+}
+
+func parseUint(query url.Values, key string) (uint, error) {
+	str := query.Get(key)
+	value, err := strconv.ParseUint(str, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %s", key, str)
+	}
+	return uint(value), nil
+}
+
+func placeEntity(game *GameSession, query url.Values) error {
+	coords, err := parseCoords(query)
+	if err != nil {
+		return err
+	}
+	player, err := strconv.Atoi(query.Get("player"))
+	if err != nil {
+		return fmt.Errorf("invalid player: %s", query.Get("player"))
+	}
+
+	return game.Place(coords, Entity{
+		Type:      EntityType(query.Get("type")),
+		Player:    player,
+		HasFlower: query.Get("hasFlower") == "true",
+	})
+}
+
+func removeEntity(game *GameSession, query url.Values) error {
+	coords, err := parseCoords(query)
+	if err != nil {
+		return err
+	}
+	return game.Remove(coords)
+}
+
+// Sets the flowers of a field with coords, or of a player with player
+
+func setResources(game *GameSession, query url.Values) error {
+	flowers, err := parseUint(query, "flowers")
+	if err != nil {
+		return err
+	}
+
+	if query.Has("player") {
+		player, err := strconv.Atoi(query.Get("player"))
+		if err != nil {
+			return fmt.Errorf("invalid player: %s", query.Get("player"))
+		}
+		return game.SetPlayerFlowers(player, flowers)
+	}
+
+	coords, err := parseCoords(query)
+	if err != nil {
+		return err
+	}
+	return game.SetFieldFlowers(coords, flowers)
+}
+
+func rewind(game *GameSession, query url.Values) error {
+	turn, err := parseUint(query, "turn")
+	if err != nil {
+		return err
+	}
+	return game.Rewind(turn)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	. "hive-arena/common"
+)
+
+// Scenarios are JSON files of the scenario directory, named after the file
+// without its extension
+
+func loadScenario(name string) (Scenario, error) {
+	if name != filepath.Base(name) {
+		return Scenario{}, fmt.Errorf("invalid name: %s", name)
+	}
+	return LoadScenario(filepath.Join(ScenarioDir, name+".json"))
+}
+
+// The state of a game on the turn given in the query, or on its last turn if
+// there is none
+
+func stateAt(history []Turn, turnStr string) (*GameState, bool) {
+	if len(history) == 0 {
+		return nil, false
+	}
+	if turnStr == "" {
+		return history[len(history)-1].State, true
+	}
+
+	turn, err := strconv.ParseUint(turnStr, 10, 0)
+	if err != nil {
+		return nil, false
+	}
+	for _, entry := range history {
+		if entry.State.Turn == uint(turn) {
+			return entry.State, true
+		}
+	}
+	return nil, false
+}
+
+func (session *GameSession) ScenarioAt(turn string) (Scenario, bool) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	state, found := stateAt(session.History, turn)
+	if !found {
+		return Scenario{}, false
+	}
+	return ScenarioOf(state), true
+}
+
+// Exports a turn of a live game as a scenario. The whole board is visible, so
+// this needs the admin token of the game.
+
+func (server *Server) handleScenario(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	game := server.adminGame(w, r)
+	if game == nil {
+		return
+	}
+
+	turn := r.URL.Query().Get("turn")
+	scenario, found := game.ScenarioAt(turn)
+	if !found {
+		writeJson(w, "Invalid turn: "+turn, http.StatusBadRequest)
+		return
+	}
+	writeJson(w, scenario, http.StatusOK)
+}
+
+// Exports a turn of a finished game as a scenario
+
+func (server *Server) handleHistoryScenario(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	id := r.PathValue("id")
+
+	path, found := server.findHistory(id)
+	if !found {
+		writeJson(w, "Invalid game id: "+id, http.StatusNotFound)
+		return
+	}
+
+	game, err := LoadPersistedGame(path)
+	if err != nil {
+		writeJson(w, "Could not load game: "+id, http.StatusInternalServerError)
+		return
+	}
+
+	turn := r.URL.Query().Get("turn")
+	state, found := stateAt(game.History, turn)
+	if !found {
+		writeJson(w, "Invalid turn: "+turn, http.StatusBadRequest)
+		return
+	}
+	writeJson(w, ScenarioOf(state), http.StatusOK)
+}
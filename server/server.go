@@ -3,10 +3,13 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"maps"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
@@ -16,17 +19,25 @@ import (
 	"github.com/gorilla/websocket"
 
 	. "hive-arena/common"
+	"hive-arena/runner"
 )
 
 const MapDir = "maps"
+const ScenarioDir = "scenarios"
 const HistoryDir = "history"
 const GameStartTimeout = 5 * time.Minute
+const DefaultPageSize = 50
+const MaxPageSize = 500
 
 type Server struct {
 	mutex sync.Mutex
 
-	Maps     map[string]MapData
-	Sessions map[string]*GameSession
+	Maps        map[string]MapData
+	Sessions    map[string]*GameSession
+	History     *HistoryIndex
+	Tournaments map[string]*Tournament
+	Matchmaker  *Matchmaker
+	Registry    *Registry
 }
 
 func loadMaps() map[string]MapData {
@@ -70,47 +81,165 @@ func (server *Server) handleNewGame(w http.ResponseWriter, r *http.Request) {
 	logRoute(r)
 
 	mapname := r.URL.Query().Get("map")
+	scenarioName := r.URL.Query().Get("scenario")
+
+	var state *GameState
+	if scenarioName != "" {
+		scenario, err := loadScenario(scenarioName)
+		if err != nil {
+			writeJson(w, "Invalid scenario: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if state, err = scenario.State(server.Maps); err != nil {
+			writeJson(w, "Invalid scenario: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if state.GameOver {
+			writeJson(w, "Invalid scenario: the game is over on turn "+strconv.FormatUint(uint64(state.Turn), 10), http.StatusBadRequest)
+			return
+		}
+		mapname = scenario.Map
+		if mapname == "" {
+			mapname = scenarioName
+		}
+	}
+
 	mapdata, mapfound := server.Maps[mapname]
-	if !mapfound {
+	if !mapfound && state == nil {
 		writeJson(w, "Map not found: "+mapname, http.StatusBadRequest)
 		return
 	}
 
 	playerStr := r.URL.Query().Get("players")
 	players, ok := strconv.Atoi(playerStr)
+	if state != nil && playerStr == "" {
+		players, ok = state.NumPlayers, nil
+	}
 	if ok != nil || !IsValidNumPlayers(players) {
 		writeJson(w, "Invalid number of players: "+playerStr, http.StatusBadRequest)
 		return
 	}
+	if state != nil && players != state.NumPlayers {
+		writeJson(w, "Invalid number of players: the scenario has "+strconv.Itoa(state.NumPlayers), http.StatusBadRequest)
+		return
+	}
 
-	server.mutex.Lock()
-	id := GenerateUniqueID(server.Sessions)
-	game := NewGameSession(id, players, mapname, mapdata)
-	server.Sessions[id] = game
-	server.mutex.Unlock()
+	timing, err := Bounds.Parse(r.URL.Query())
+	if err != nil {
+		writeJson(w, "Invalid turn timing: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	time.AfterFunc(GameStartTimeout, func() { server.removeIfNotStarted(id) })
-	server.removeIfOver(id)
+	var botNames []string
+	if str := r.URL.Query().Get("bots"); str != "" {
+		botNames = strings.Split(str, ",")
+	}
+	fill := r.URL.Query().Get("fill")
+	practice := r.URL.Query().Get("practice") == "true"
 
-	log.Printf("Created game %s (%s, %d players)", id, mapname, players)
+	for _, name := range append(slices.Clone(botNames), fill) {
+		if name != "" && !isValidBot(server.Registry, name) {
+			writeJson(w, "Invalid bot: "+name, http.StatusBadRequest)
+			return
+		}
+	}
+	if len(botNames) > players {
+		writeJson(w, "Too many bots: "+strconv.Itoa(len(botNames)), http.StatusBadRequest)
+		return
+	}
+
+	if state == nil {
+		state = NewGameState(mapdata, players)
+	}
+
+	game := server.createGame(mapname, state, timing, func(game *GameSession) {
+		game.Scenario = scenarioName
+		game.FillBot = fill
+		game.Practice = practice
+	})
+
+	for _, name := range botNames {
+		if _, err := game.AddBot(name); err != nil {
+			server.removeGame(game.ID)
+			writeJson(w, "Could not start "+name+": "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 
 	writeJson(w, map[string]any{
 		"id":          game.ID,
 		"numPlayers":  game.State.NumPlayers,
 		"map":         game.Map,
+		"scenario":    game.Scenario,
+		"practice":    game.Practice,
 		"createdDate": game.CreatedDate,
+		"timing":      game.State.Timing,
 		"adminToken":  game.AdminToken,
 	}, http.StatusOK)
 }
 
-func (server *Server) removeIfNotStarted(id string) {
+// Creates a session and makes it available to players. setup, if any, is called
+// before, to set the fields that joining depends on.
+
+func (server *Server) createGame(mapname string, state *GameState, timing TurnTiming, setup func(*GameSession)) *GameSession {
+	server.mutex.Lock()
+	id := GenerateUniqueID(server.Sessions)
+	game := NewGameSession(id, mapname, state, timing)
+	game.OnPersist = server.onPersist
+	game.Registry = server.Registry
+	if setup != nil {
+		setup(game)
+	}
+	server.Sessions[id] = game
+	server.mutex.Unlock()
+
+	time.AfterFunc(GameStartTimeout, func() { server.removeIfNotStarted(id) })
+	server.removeIfOver(id)
+
+	log.Printf("Created game %s (%s, %d players)", id, mapname, state.NumPlayers)
+
+	return game
+}
+
+func (server *Server) onPersist(path string, game *PersistedGame) {
+	server.History.Add(path, game)
+
 	server.mutex.Lock()
 	defer server.mutex.Unlock()
 
+	for _, tournament := range server.Tournaments {
+		go tournament.Record(game.Summary())
+	}
+}
+
+func (server *Server) removeIfNotStarted(id string) {
+	server.mutex.Lock()
 	game := server.Sessions[id]
-	if game != nil && !game.IsFull() {
-		delete(server.Sessions, id)
-		log.Printf("Removed game %s because of timeout", id)
+	server.mutex.Unlock()
+
+	if game == nil || game.IsFull() {
+		return
+	}
+
+	if game.FillBot != "" {
+		if err := game.FillWithBots(game.FillBot); err == nil {
+			log.Printf("Filled game %s with bots because of timeout", id)
+			return
+		}
+	}
+
+	server.removeGame(id)
+	log.Printf("Removed game %s because of timeout", id)
+}
+
+func (server *Server) removeGame(id string) {
+	server.mutex.Lock()
+	game := server.Sessions[id]
+	delete(server.Sessions, id)
+	server.mutex.Unlock()
+
+	if game != nil {
+		game.Shutdown()
 	}
 }
 
@@ -153,6 +282,31 @@ func (server *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 	writeJson(w, status, http.StatusOK)
 }
 
+// Identifies an agent by its key, or by its name if it is not registered.
+// Returns the verified name and the agent ID, empty for unregistered agents.
+
+func (server *Server) identify(w http.ResponseWriter, query url.Values) (string, string, bool) {
+	if key := query.Get("key"); key != "" {
+		agent, err := server.Registry.Authenticate(key)
+		if err != nil {
+			writeJson(w, "Invalid key: "+err.Error(), http.StatusForbidden)
+			return "", "", false
+		}
+		return agent.Name, agent.ID, true
+	}
+
+	name := query.Get("name")
+	if name == "" {
+		writeJson(w, "Invalid name", http.StatusBadRequest)
+		return "", "", false
+	}
+	if _, found := server.Registry.Lookup(name); found {
+		writeJson(w, "Name is registered, a key is required: "+name, http.StatusForbidden)
+		return "", "", false
+	}
+	return name, "", true
+}
+
 func (server *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
 	logRoute(r)
 
@@ -163,13 +317,16 @@ func (server *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	name := r.URL.Query().Get("name")
-	if name == "" {
-		writeJson(w, "Invalid name", http.StatusBadRequest)
+	name, agentID, ok := server.identify(w, r.URL.Query())
+	if !ok {
 		return
 	}
 
-	player := game.AddPlayer(name)
+	player := game.AddPlayer(name, agentID)
+	if player == nil && game.Reserved != nil && !game.IsFull() {
+		writeJson(w, "No seat kept for: "+name, http.StatusBadRequest)
+		return
+	}
 	if player == nil {
 		writeJson(w, "Game is full", http.StatusBadRequest)
 		return
@@ -187,6 +344,54 @@ func (server *Server) handleJoin(w http.ResponseWriter, r *http.Request) {
 	}, http.StatusOK)
 }
 
+func (server *Server) handleQueue(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	query := r.URL.Query()
+
+	name, agentID, ok := server.identify(w, query)
+	if !ok {
+		return
+	}
+
+	playerStr := query.Get("players")
+	players, err := strconv.Atoi(playerStr)
+	if err != nil || !IsValidNumPlayers(players) {
+		writeJson(w, "Invalid number of players: "+playerStr, http.StatusBadRequest)
+		return
+	}
+
+	mapname := query.Get("map")
+	if _, found := server.Maps[mapname]; mapname != "" && !found {
+		writeJson(w, "Map not found: "+mapname, http.StatusBadRequest)
+		return
+	}
+
+	timeout := DefaultQueueTimeout
+	if str := query.Get("timeout"); str != "" {
+		seconds, err := strconv.Atoi(str)
+		if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > MaxQueueTimeout {
+			writeJson(w, "Invalid timeout: "+str, http.StatusBadRequest)
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	assignment, ok, err := server.Matchmaker.Wait(r.Context(), name, agentID, QueueKey{players, mapname}, timeout)
+	if err != nil {
+		writeJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		writeJson(w, "No game found", http.StatusRequestTimeout)
+		return
+	}
+
+	log.Printf("Player %s joined game %s from the queue (#%d)", name, assignment.GameID, assignment.Player)
+
+	writeJson(w, assignment, http.StatusOK)
+}
+
 func (server *Server) handleGame(w http.ResponseWriter, r *http.Request) {
 	logRoute(r)
 
@@ -255,6 +460,515 @@ func (server *Server) handleOrders(w http.ResponseWriter, r *http.Request) {
 	writeJson(w, "OK", http.StatusOK)
 }
 
+func (server *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	id := r.URL.Query().Get("id")
+
+	if game := server.getGameSync(id); game != nil {
+		if !game.State.GameOver {
+			writeJson(w, "Game is not over", http.StatusBadRequest)
+			return
+		}
+
+		writeJson(w, map[string]any{
+			"id":      game.ID,
+			"players": game.Status().Players,
+			"stats":   game.Stats(),
+		}, http.StatusOK)
+		return
+	}
+
+	persisted, err := server.History.Load(id)
+	if err != nil {
+		writeJson(w, "Invalid game id: "+id, http.StatusBadRequest)
+		return
+	}
+
+	stats := persisted.Stats
+	if stats == nil {
+		stats = ComputeStats(persisted.History)
+	}
+
+	writeJson(w, map[string]any{
+		"id":      persisted.Id,
+		"players": persisted.Players,
+		"stats":   stats,
+	}, http.StatusOK)
+}
+
+func (server *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	query := r.URL.Query()
+	filter := HistoryFilter{
+		Player: query.Get("player"),
+		Agent:  query.Get("agent"),
+		Map:    query.Get("map"),
+	}
+
+	var err error
+	if filter.From, err = parseDate(query.Get("from"), false); err != nil {
+		writeJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseDate(query.Get("to"), true); err != nil {
+		writeJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	offset, limit := 0, DefaultPageSize
+	if str := query.Get("offset"); str != "" {
+		if offset, err = strconv.Atoi(str); err != nil || offset < 0 {
+			writeJson(w, "Invalid offset: "+str, http.StatusBadRequest)
+			return
+		}
+	}
+	if str := query.Get("limit"); str != "" {
+		if limit, err = strconv.Atoi(str); err != nil || limit < 1 || limit > MaxPageSize {
+			writeJson(w, "Invalid limit: "+str, http.StatusBadRequest)
+			return
+		}
+	}
+
+	games, total := server.History.Query(filter, offset, limit)
+
+	writeJson(w, map[string]any{
+		"total":  total,
+		"offset": offset,
+		"limit":  limit,
+		"games":  games,
+	}, http.StatusOK)
+}
+
+// The history file of a game, by game ID or file name
+
+func (server *Server) findHistory(id string) (string, bool) {
+	if path, found := historyFileByName(id); found {
+		return path, true
+	}
+	path, err := server.History.Find(id)
+	return path, err == nil
+}
+
+func (server *Server) handleHistoryGame(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	id := r.PathValue("id")
+
+	path, found := server.findHistory(id)
+	if !found {
+		writeJson(w, "Invalid game id: "+id, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeFile(w, r, path)
+}
+
+func (server *Server) handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	board := ComputeRatings(server.History.Summaries()).Leaderboard()
+	for i := range board {
+		if r.URL.Query().Get("history") == "false" {
+			board[i].History = nil
+		}
+
+		// Show registered agents under their current name
+
+		if name, found := server.Registry.Name(board[i].ID); found {
+			board[i].Name = name
+		}
+	}
+
+	writeJson(w, map[string]any{"agents": board}, http.StatusOK)
+}
+
+func (server *Server) handleNewTournament(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	query := r.URL.Query()
+
+	format := TournamentFormat(strings.ToUpper(strings.ReplaceAll(query.Get("format"), "-", "_")))
+	if !slices.Contains([]TournamentFormat{ROUND_ROBIN, SWISS, ELIMINATION}, format) {
+		writeJson(w, "Invalid format: "+query.Get("format"), http.StatusBadRequest)
+		return
+	}
+
+	playerStr := query.Get("players")
+	players, err := strconv.Atoi(playerStr)
+	if err != nil || !IsValidNumPlayers(players) {
+		writeJson(w, "Invalid number of players: "+playerStr, http.StatusBadRequest)
+		return
+	}
+
+	maps := strings.Split(query.Get("maps"), ",")
+	for _, mapname := range maps {
+		if _, found := server.Maps[mapname]; !found {
+			writeJson(w, "Map not found: "+mapname, http.StatusBadRequest)
+			return
+		}
+	}
+
+	timing, err := Bounds.Parse(query)
+	if err != nil {
+		writeJson(w, "Invalid turn timing: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rounds, err := parseCount(query, "rounds")
+	if err != nil {
+		writeJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bestOf, err := parseCount(query, "bestOf")
+	if err != nil {
+		writeJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var agents []string
+	if str := query.Get("agents"); str != "" {
+		agents = strings.Split(str, ",")
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	id := GenerateUniqueID(server.Tournaments)
+	tournament := NewTournament(server, id, format, players, maps, timing)
+	tournament.Rounds = rounds
+	tournament.BestOf = bestOf
+
+	// Registered agents are entered under their ID, and will need their key
+
+	for _, name := range agents {
+		agent, _ := server.Registry.Lookup(name)
+		if agent.ID != "" {
+			name = agent.Name
+		}
+		if err := tournament.Register(name, agent.ID); err != nil {
+			writeJson(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	server.Tournaments[id] = tournament
+
+	log.Printf("Created tournament %s (%s, %d players per game)", id, format, players)
+
+	writeJson(w, map[string]any{
+		"id":         id,
+		"adminToken": tournament.AdminToken,
+	}, http.StatusOK)
+}
+
+// Reads an optional positive integer from the query string
+
+func parseCount(query url.Values, key string) (int, error) {
+	str := query.Get(key)
+	if str == "" {
+		return 0, nil
+	}
+
+	count, err := strconv.Atoi(str)
+	if err != nil || count < 1 {
+		return 0, fmt.Errorf("Invalid %s: %s", key, str)
+	}
+	return count, nil
+}
+
+func (server *Server) getTournament(w http.ResponseWriter, r *http.Request) *Tournament {
+	id := r.PathValue("id")
+
+	server.mutex.Lock()
+	tournament := server.Tournaments[id]
+	server.mutex.Unlock()
+
+	if tournament == nil {
+		writeJson(w, "Invalid tournament id: "+id, http.StatusNotFound)
+	}
+	return tournament
+}
+
+func (server *Server) handleTournament(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	if tournament := server.getTournament(w, r); tournament != nil {
+		writeJson(w, tournament.Report(), http.StatusOK)
+	}
+}
+
+func (server *Server) handleTournamentRegister(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	tournament := server.getTournament(w, r)
+	if tournament == nil {
+		return
+	}
+
+	name, agentID, ok := server.identify(w, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	writeResult(w, tournament.Register(name, agentID))
+}
+
+func (server *Server) handleTournamentStart(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	tournament := server.getTournament(w, r)
+	if tournament == nil {
+		return
+	}
+
+	if r.URL.Query().Get("token") != tournament.AdminToken {
+		writeJson(w, "Invalid token", http.StatusForbidden)
+		return
+	}
+
+	writeResult(w, tournament.Start())
+}
+
+func (server *Server) handleTournamentNext(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	tournament := server.getTournament(w, r)
+	if tournament == nil {
+		return
+	}
+
+	name, agentID, ok := server.identify(w, r.URL.Query())
+	if !ok {
+		return
+	}
+
+	assignment, ok, err := tournament.Wait(r.Context(), name, agentID)
+	if err != nil {
+		writeJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !ok {
+		writeJson(w, map[string]any{"finished": tournament.IsFinished()}, http.StatusOK)
+		return
+	}
+
+	writeJson(w, assignment, http.StatusOK)
+}
+
+// Checks the game id and admin token of an admin route
+
+func (server *Server) adminGame(w http.ResponseWriter, r *http.Request) *GameSession {
+	id := r.URL.Query().Get("id")
+	game := server.getGameSync(id)
+	if game == nil {
+		writeJson(w, "Invalid game id: "+id, http.StatusBadRequest)
+		return nil
+	}
+
+	if r.URL.Query().Get("token") != game.AdminToken {
+		writeJson(w, "Invalid token", http.StatusForbidden)
+		return nil
+	}
+
+	return game
+}
+
+func writeResult(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, "OK", http.StatusOK)
+}
+
+func (server *Server) handleAdmin(action func(*GameSession) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logRoute(r)
+
+		game := server.adminGame(w, r)
+		if game == nil {
+			return
+		}
+
+		writeResult(w, action(game))
+	}
+}
+
+func (server *Server) handleForfeit(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	game := server.adminGame(w, r)
+	if game == nil {
+		return
+	}
+
+	playerStr := r.URL.Query().Get("player")
+	player, err := strconv.Atoi(playerStr)
+	if err != nil {
+		writeJson(w, "Invalid player: "+playerStr, http.StatusBadRequest)
+		return
+	}
+
+	writeResult(w, game.Forfeit(player))
+}
+
+func (server *Server) handleRegisterAgent(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	agent, key, err := server.Registry.Register(r.URL.Query().Get("name"))
+	if err != nil {
+		writeJson(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJson(w, map[string]any{
+		"id":   agent.ID,
+		"name": agent.Name,
+		"key":  key,
+	}, http.StatusOK)
+}
+
+// Uploads the WebAssembly module of a registered agent, which can then play as
+// a bot named wasm:<agent name>
+
+func (server *Server) handleUploadModule(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	agent, err := server.Registry.Authenticate(r.URL.Query().Get("key"))
+	if err != nil {
+		writeJson(w, "Invalid key: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	module, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxWasmSize))
+	if err != nil {
+		writeJson(w, "Invalid module: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := runner.CompileWasm(module, uint64(WasmMemory)<<20); err != nil {
+		writeJson(w, "Invalid module: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := saveModule(agent.ID, module); err != nil {
+		log.Printf("Could not save module of agent %s: %s", agent.ID, err)
+		writeJson(w, "Could not save module", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Uploaded module of agent %s (%s), %d bytes", agent.Name, agent.ID, len(module))
+
+	writeJson(w, map[string]any{
+		"id":   agent.ID,
+		"name": agent.Name,
+		"bot":  WasmPrefix + agent.Name,
+		"size": len(module),
+	}, http.StatusOK)
+}
+
+// Uploads the Lua scripts of a registered agent, as files of a multipart form,
+// which can then play as a bot named lua:<agent name>. The scripts must include
+// main.lua, and replace all the previous ones.
+
+func (server *Server) handleUploadScripts(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	agent, err := server.Registry.Authenticate(r.URL.Query().Get("key"))
+	if err != nil {
+		writeJson(w, "Invalid key: "+err.Error(), http.StatusForbidden)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxLuaSize+64<<10)
+	if err := r.ParseMultipartForm(MaxLuaSize); err != nil {
+		writeJson(w, "Invalid scripts: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scripts := make(map[string][]byte)
+	size := 0
+	for _, headers := range r.MultipartForm.File {
+		for _, header := range headers {
+			name := header.Filename
+			if name != filepath.Base(name) || !strings.HasSuffix(name, ".lua") {
+				writeJson(w, "Invalid script name: "+name, http.StatusBadRequest)
+				return
+			}
+
+			file, err := header.Open()
+			if err != nil {
+				writeJson(w, "Invalid script: "+name, http.StatusBadRequest)
+				return
+			}
+			source, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				writeJson(w, "Invalid script: "+name, http.StatusBadRequest)
+				return
+			}
+
+			scripts[name] = source
+			size += len(source)
+		}
+	}
+	if size > MaxLuaSize {
+		writeJson(w, "Invalid scripts: too large", http.StatusBadRequest)
+		return
+	}
+
+	if err := saveScripts(agent.ID, scripts); err != nil {
+		writeJson(w, "Invalid scripts: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("Uploaded scripts of agent %s (%s), %d files", agent.Name, agent.ID, len(scripts))
+
+	writeJson(w, map[string]any{
+		"id":    agent.ID,
+		"name":  agent.Name,
+		"bot":   LuaPrefix + agent.Name,
+		"files": slices.Sorted(maps.Keys(scripts)),
+	}, http.StatusOK)
+}
+
+func (server *Server) handleAgents(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	writeJson(w, map[string]any{"agents": server.Registry.List()}, http.StatusOK)
+}
+
+// Checks the server admin token, set on the command line
+
+func checkServerAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if ServerAdminToken == "" || r.URL.Query().Get("token") != ServerAdminToken {
+		writeJson(w, "Invalid token", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (server *Server) handleRevokeAgent(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	if checkServerAdmin(w, r) {
+		writeResult(w, server.Registry.Revoke(r.URL.Query().Get("id")))
+	}
+}
+
+func (server *Server) handleRenameAgent(w http.ResponseWriter, r *http.Request) {
+	logRoute(r)
+
+	if checkServerAdmin(w, r) {
+		query := r.URL.Query()
+		writeResult(w, server.Registry.Rename(query.Get("id"), query.Get("name")))
+	}
+}
+
 func (server *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	logRoute(r)
 
@@ -278,19 +992,54 @@ func (server *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 func RunServer(port int) {
 
 	server := Server{
-		Maps:     loadMaps(),
-		Sessions: make(map[string]*GameSession),
+		Maps:        loadMaps(),
+		Sessions:    make(map[string]*GameSession),
+		History:     loadHistoryIndex(),
+		Tournaments: make(map[string]*Tournament),
+		Registry:    LoadRegistry(AgentsFile),
 	}
+	server.Matchmaker = NewMatchmaker(&server)
 
 	http.HandleFunc("GET /newgame", server.handleNewGame)
+	http.HandleFunc("POST /fork", server.handleFork)
 	http.HandleFunc("GET /status", server.handleStatus)
 	http.HandleFunc("GET /join", server.handleJoin)
+	http.HandleFunc("GET /queue", server.handleQueue)
 	http.HandleFunc("GET /game", server.handleGame)
 	http.HandleFunc("POST /orders", server.handleOrders)
 	http.HandleFunc("GET /ws", server.handleWebSocket)
-
-	fs := http.FileServer(http.Dir("./" + HistoryDir + "/"))
-	http.Handle("GET /history/", http.StripPrefix("/history/", fs))
+	http.HandleFunc("GET /stats", server.handleStats)
+
+	http.HandleFunc("POST /admin/pause", server.handleAdmin((*GameSession).Pause))
+	http.HandleFunc("POST /admin/resume", server.handleAdmin((*GameSession).Resume))
+	http.HandleFunc("POST /admin/step", server.handleAdmin((*GameSession).Step))
+	http.HandleFunc("POST /admin/abort", server.handleAdmin((*GameSession).Abort))
+	http.HandleFunc("POST /admin/forfeit", server.handleForfeit)
+	http.HandleFunc("GET /admin/scenario", server.handleScenario)
+	http.HandleFunc("POST /admin/sandbox/place", server.handleSandbox(placeEntity))
+	http.HandleFunc("POST /admin/sandbox/remove", server.handleSandbox(removeEntity))
+	http.HandleFunc("POST /admin/sandbox/resources", server.handleSandbox(setResources))
+	http.HandleFunc("POST /admin/sandbox/rewind", server.handleSandbox(rewind))
+
+	http.HandleFunc("POST /agents/register", server.handleRegisterAgent)
+	http.HandleFunc("GET /agents", server.handleAgents)
+	http.HandleFunc("POST /agents/module", server.handleUploadModule)
+	http.HandleFunc("POST /agents/lua", server.handleUploadScripts)
+	http.HandleFunc("POST /admin/agents/revoke", server.handleRevokeAgent)
+	http.HandleFunc("POST /admin/agents/rename", server.handleRenameAgent)
+
+	http.HandleFunc("GET /newtournament", server.handleNewTournament)
+	http.HandleFunc("GET /tournament/{id}", server.handleTournament)
+	http.HandleFunc("POST /tournament/{id}/register", server.handleTournamentRegister)
+	http.HandleFunc("POST /tournament/{id}/start", server.handleTournamentStart)
+	http.HandleFunc("GET /tournament/{id}/next", server.handleTournamentNext)
+
+	http.HandleFunc("GET /leaderboard", server.handleLeaderboard)
+
+	http.HandleFunc("GET /history", server.handleHistory)
+	http.HandleFunc("GET /history/{$}", server.handleHistory)
+	http.HandleFunc("GET /history/{id}", server.handleHistoryGame)
+	http.HandleFunc("GET /history/{id}/scenario", server.handleHistoryScenario)
 
 	log.Printf("Listening on port %d", port)
 
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	. "hive-arena/common"
+)
+
+const DefaultMinTurnDuration = 500 * time.Millisecond
+const DefaultTurnTimeout = 2 * time.Second
+
+// Server-wide limits for the timing values a game can request
+
+type TimingBounds struct {
+	MinTurnDuration time.Duration
+	MaxTurnDuration time.Duration
+	MinTurnTimeout  time.Duration
+	MaxTurnTimeout  time.Duration
+	MaxTimeBank     time.Duration
+
+	// The minimum turn duration of games that do not ask for one, none in
+	// development mode
+
+	DefaultTurnDuration time.Duration
+}
+
+func clamp(d, low, high time.Duration) time.Duration {
+	return max(low, min(d, high))
+}
+
+func (bounds TimingBounds) Default() TurnTiming {
+	return TurnTiming{
+		MinTurnDuration: Duration(clamp(bounds.DefaultTurnDuration, bounds.MinTurnDuration, bounds.MaxTurnDuration)),
+		TurnTimeout:     Duration(clamp(DefaultTurnTimeout, bounds.MinTurnTimeout, bounds.MaxTurnTimeout)),
+	}
+}
+
+// Brings a timing within the bounds, such as one read from an older history file
+
+func (bounds TimingBounds) Clamp(timing TurnTiming) TurnTiming {
+	if timing.TurnTimeout == 0 {
+		return bounds.Default()
+	}
+
+	timing.MinTurnDuration = Duration(clamp(time.Duration(timing.MinTurnDuration), bounds.MinTurnDuration, bounds.MaxTurnDuration))
+	timing.TurnTimeout = Duration(clamp(time.Duration(timing.TurnTimeout), bounds.MinTurnTimeout, bounds.MaxTurnTimeout))
+	timing.TimeBank = Duration(min(time.Duration(timing.TimeBank), bounds.MaxTimeBank))
+	timing.Increment = Duration(min(time.Duration(timing.Increment), bounds.MaxTurnTimeout))
+	if timing.TimeBank == 0 {
+		timing.Increment = 0
+	}
+	return timing
+}
+
+func (bounds TimingBounds) Check(timing TurnTiming) error {
+	duration := time.Duration(timing.MinTurnDuration)
+	if duration < bounds.MinTurnDuration || duration > bounds.MaxTurnDuration {
+		return fmt.Errorf("minimum turn duration must be between %v and %v", bounds.MinTurnDuration, bounds.MaxTurnDuration)
+	}
+
+	timeout := time.Duration(timing.TurnTimeout)
+	if timeout < bounds.MinTurnTimeout || timeout > bounds.MaxTurnTimeout {
+		return fmt.Errorf("turn timeout must be between %v and %v", bounds.MinTurnTimeout, bounds.MaxTurnTimeout)
+	}
+
+	if time.Duration(timing.TimeBank) > bounds.MaxTimeBank {
+		return fmt.Errorf("time bank must be at most %v", bounds.MaxTimeBank)
+	}
+
+	if time.Duration(timing.Increment) > bounds.MaxTurnTimeout {
+		return fmt.Errorf("increment must be at most %v", bounds.MaxTurnTimeout)
+	}
+
+	if timing.TimeBank == 0 && timing.Increment != 0 {
+		return fmt.Errorf("increment requires a time bank")
+	}
+
+	return nil
+}
+
+// Reads an optional duration in milliseconds from the query string
+
+func parseMillis(query url.Values, key string, value *Duration) error {
+	str := query.Get(key)
+	if str == "" {
+		return nil
+	}
+
+	ms, err := strconv.Atoi(str)
+	if err != nil || ms < 0 {
+		return fmt.Errorf("invalid %s: %s", key, str)
+	}
+
+	*value = Duration(time.Duration(ms) * time.Millisecond)
+	return nil
+}
+
+func (bounds TimingBounds) Parse(query url.Values) (TurnTiming, error) {
+	timing := bounds.Default()
+
+	if err := parseMillis(query, "minTurnDuration", &timing.MinTurnDuration); err != nil {
+		return timing, err
+	}
+	if err := parseMillis(query, "turnTimeout", &timing.TurnTimeout); err != nil {
+		return timing, err
+	}
+	if err := parseMillis(query, "timeBank", &timing.TimeBank); err != nil {
+		return timing, err
+	}
+	if err := parseMillis(query, "increment", &timing.Increment); err != nil {
+		return timing, err
+	}
+
+	return timing, bounds.Check(timing)
+}
@@ -0,0 +1,608 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/bits"
+	"slices"
+	"sync"
+	"time"
+
+	. "hive-arena/common"
+)
+
+const TournamentWaitTimeout = 60 * time.Second
+const SwissPairingBudget = 100000
+
+type TournamentFormat string
+
+const (
+	ROUND_ROBIN TournamentFormat = "ROUND_ROBIN"
+	SWISS       TournamentFormat = "SWISS"
+	ELIMINATION TournamentFormat = "ELIMINATION"
+)
+
+type TournamentGameStatus string
+
+const (
+	SCHEDULED TournamentGameStatus = "SCHEDULED"
+	RUNNING   TournamentGameStatus = "RUNNING"
+	FINISHED  TournamentGameStatus = "FINISHED"
+)
+
+type TournamentGame struct {
+	Round   int                  `json:"round"`
+	Map     string               `json:"map"`
+	Seats   []string             `json:"seats"`
+	GameID  string               `json:"gameId,omitempty"`
+	Status  TournamentGameStatus `json:"status"`
+	Winners []string             `json:"winners,omitempty"`
+	Flowers []uint               `json:"flowers,omitempty"`
+	Match   *Match               `json:"-"`
+}
+
+// A best-of-N series between two agents, in elimination tournaments
+
+type Match struct {
+	Agents [2]string `json:"agents"`
+	Wins   [2]int    `json:"wins"`
+	Games  int       `json:"games"`
+	Winner string    `json:"winner,omitempty"`
+}
+
+type Standing struct {
+	Name    string  `json:"name"`
+	Points  float64 `json:"points"`
+	Played  int     `json:"played"`
+	Wins    int     `json:"wins"`
+	Flowers uint    `json:"flowers"`
+	Byes    int     `json:"byes,omitzero"`
+}
+
+// What an agent waiting in the queue receives when its next game starts
+
+type Assignment struct {
+	GameID string `json:"id"`
+	Player int    `json:"player"`
+	Token  string `json:"token"`
+}
+
+type Tournament struct {
+	mutex sync.Mutex
+
+	ID             string            `json:"id"`
+	AdminToken     string            `json:"-"`
+	Format         TournamentFormat  `json:"format"`
+	Agents         []string          `json:"agents"`
+	AgentIds       map[string]string `json:"agentIds,omitempty"`
+	PlayersPerGame int               `json:"playersPerGame"`
+	Maps           []string          `json:"maps"`
+	Rounds         int               `json:"rounds"`
+	BestOf         int               `json:"bestOf,omitzero"`
+	Timing         TurnTiming        `json:"timing"`
+
+	Started  bool              `json:"started"`
+	Finished bool              `json:"finished"`
+	Round    int               `json:"round"`
+	Games    []*TournamentGame `json:"games"`
+	Matches  []*Match          `json:"matches,omitempty"`
+
+	standings map[string]*Standing
+	waiting   map[string]chan Assignment
+	server    *Server
+
+	// Games created by dispatch, whose agents are seated once the tournament
+	// is unlocked, as seating the last one starts the game
+
+	starting []gameStart
+}
+
+type gameStart struct {
+	session  *GameSession
+	seats    []string
+	agentIds []string
+	channels []chan Assignment
+}
+
+func NewTournament(server *Server, id string, format TournamentFormat, players int, maps []string, timing TurnTiming) *Tournament {
+	return &Tournament{
+		ID:             id,
+		AdminToken:     generateTokens(1)[0],
+		Format:         format,
+		PlayersPerGame: players,
+		Maps:           maps,
+		Timing:         timing,
+		AgentIds:       make(map[string]string),
+		standings:      make(map[string]*Standing),
+		waiting:        make(map[string]chan Assignment),
+		server:         server,
+	}
+}
+
+// Registered agents enter under their verified name, and must then identify
+// with their key. The agent ID is empty for unregistered agents.
+
+func (t *Tournament) Register(name string, agentID string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.Started {
+		return fmt.Errorf("tournament has already started")
+	}
+	if slices.Contains(t.Agents, name) {
+		return fmt.Errorf("agent already registered: %s", name)
+	}
+
+	t.Agents = append(t.Agents, name)
+	if agentID != "" {
+		t.AgentIds[name] = agentID
+	}
+	t.standings[name] = &Standing{Name: name}
+	return nil
+}
+
+func (t *Tournament) Start() error {
+	t.mutex.Lock()
+	defer t.unlock()
+
+	if t.Started {
+		return fmt.Errorf("tournament has already started")
+	}
+	if len(t.Agents) < t.PlayersPerGame {
+		return fmt.Errorf("not enough agents registered: %d", len(t.Agents))
+	}
+	if t.Format == ELIMINATION && t.PlayersPerGame != 2 {
+		return fmt.Errorf("elimination tournaments need 2 players per game")
+	}
+
+	if t.Format == SWISS && t.Rounds == 0 {
+		t.Rounds = bits.Len(uint(len(t.Agents) - 1))
+	}
+	if t.Format == ELIMINATION && t.BestOf == 0 {
+		t.BestOf = 1
+	}
+
+	t.Started = true
+	log.Printf("Tournament %s has started (%s, %d agents)", t.ID, t.Format, len(t.Agents))
+
+	switch t.Format {
+	case ROUND_ROBIN:
+		t.scheduleRoundRobin()
+	case SWISS:
+		t.scheduleSwissRound()
+	case ELIMINATION:
+		t.scheduleBracket(t.Agents)
+	}
+
+	t.dispatch()
+	return nil
+}
+
+// Every group of agents plays once on each map, with seats rotating between maps
+
+func (t *Tournament) scheduleRoundRobin() {
+	t.Round = 1
+	for _, group := range combinations(t.Agents, t.PlayersPerGame) {
+		for i, mapname := range t.Maps {
+			t.schedule(mapname, rotate(group, i), nil)
+		}
+	}
+}
+
+func combinations(items []string, k int) [][]string {
+	if k == 0 {
+		return [][]string{{}}
+	}
+	var result [][]string
+	for i := 0; i+k <= len(items); i++ {
+		for _, rest := range combinations(items[i+1:], k-1) {
+			result = append(result, append([]string{items[i]}, rest...))
+		}
+	}
+	return result
+}
+
+func rotate(seats []string, n int) []string {
+	n %= len(seats)
+	return append(slices.Clone(seats[n:]), seats[:n]...)
+}
+
+// Agents with similar scores play each other, avoiding rematches when possible.
+// Agents left over when the field does not divide evenly get a bye, worth a win.
+
+func (t *Tournament) scheduleSwissRound() {
+	t.Round++
+	mapname := t.Maps[(t.Round-1)%len(t.Maps)]
+
+	remaining := t.Standings()
+
+	// Byes go to the lowest ranked agents who have not had one yet
+
+	var byes []Standing
+	for len(remaining)%t.PlayersPerGame != 0 {
+		pick := len(remaining) - 1
+		for i := len(remaining) - 1; i >= 0; i-- {
+			if remaining[i].Byes < remaining[pick].Byes {
+				pick = i
+			}
+		}
+		byes = append(byes, remaining[pick])
+		remaining = slices.Delete(remaining, pick, pick+1)
+	}
+
+	var names []string
+	for _, standing := range remaining {
+		names = append(names, standing.Name)
+	}
+
+	budget := SwissPairingBudget
+	groups, ok := t.groupWithoutRematches(names, &budget)
+	if !ok {
+		groups = nil
+		for i := 0; i < len(names); i += t.PlayersPerGame {
+			groups = append(groups, names[i:i+t.PlayersPerGame])
+		}
+	}
+
+	for _, group := range groups {
+		t.schedule(mapname, rotate(group, t.Round-1), nil)
+	}
+
+	for _, bye := range byes {
+		standing := t.standings[bye.Name]
+		standing.Points++
+		standing.Byes++
+	}
+}
+
+// Splits agents into groups in standings order, backtracking to avoid rematches.
+// The search gives up after a number of steps.
+
+func (t *Tournament) groupWithoutRematches(names []string, budget *int) ([][]string, bool) {
+	if len(names) == 0 {
+		return nil, true
+	}
+	return t.fillGroup(names[:1], names[1:], budget)
+}
+
+func (t *Tournament) fillGroup(group []string, remaining []string, budget *int) ([][]string, bool) {
+	if len(group) == t.PlayersPerGame {
+		groups, ok := t.groupWithoutRematches(remaining, budget)
+		return append([][]string{group}, groups...), ok
+	}
+
+	for i, name := range remaining {
+		*budget--
+		if *budget < 0 {
+			return nil, false
+		}
+		if t.havePlayed(group, name) {
+			continue
+		}
+
+		others := slices.Delete(slices.Clone(remaining), i, i+1)
+		if groups, ok := t.fillGroup(append(slices.Clone(group), name), others, budget); ok {
+			return groups, true
+		}
+	}
+
+	return nil, false
+}
+
+func (t *Tournament) havePlayed(group []string, name string) bool {
+	for _, game := range t.Games {
+		if slices.Contains(game.Seats, name) && slices.ContainsFunc(group, func(other string) bool {
+			return slices.Contains(game.Seats, other)
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pairs the best seeds with the worst ones. The top seeds get a bye when the
+// number of agents is not a power of two.
+
+func (t *Tournament) scheduleBracket(agents []string) {
+	t.Round++
+	t.Matches = nil
+
+	size := 1 << bits.Len(uint(len(agents)-1))
+	byes := size - len(agents)
+
+	for i := range size / 2 {
+		if i < byes {
+			t.Matches = append(t.Matches, &Match{Agents: [2]string{agents[i], ""}, Winner: agents[i]})
+			continue
+		}
+		opponent := size - 1 - i
+		match := &Match{Agents: [2]string{agents[i], agents[opponent]}}
+		t.Matches = append(t.Matches, match)
+		t.scheduleMatchGame(match)
+	}
+}
+
+// Seats alternate and maps rotate between the games of a match
+
+func (t *Tournament) scheduleMatchGame(match *Match) {
+	seats := match.Agents[:]
+	if match.Games%2 == 1 {
+		seats = []string{match.Agents[1], match.Agents[0]}
+	}
+	mapname := t.Maps[match.Games%len(t.Maps)]
+	t.schedule(mapname, seats, match)
+}
+
+func (t *Tournament) schedule(mapname string, seats []string, match *Match) {
+	t.Games = append(t.Games, &TournamentGame{
+		Round:  t.Round,
+		Map:    mapname,
+		Seats:  slices.Clone(seats),
+		Status: SCHEDULED,
+		Match:  match,
+	})
+}
+
+// Starts all scheduled games whose agents are all waiting in the queue
+
+func (t *Tournament) dispatch() {
+	for _, game := range t.Games {
+		ready := !slices.ContainsFunc(game.Seats, func(name string) bool {
+			return t.waiting[name] == nil
+		})
+		if game.Status != SCHEDULED || !ready {
+			continue
+		}
+
+		session := t.server.createGame(game.Map, NewGameState(t.server.Maps[game.Map], len(game.Seats)), t.Timing, nil)
+		game.GameID = session.ID
+		game.Status = RUNNING
+
+		start := gameStart{session: session, seats: game.Seats}
+		for _, name := range game.Seats {
+			start.agentIds = append(start.agentIds, t.AgentIds[name])
+			start.channels = append(start.channels, t.waiting[name])
+			delete(t.waiting, name)
+		}
+		t.starting = append(t.starting, start)
+
+		log.Printf("Tournament %s started game %s (%v)", t.ID, session.ID, game.Seats)
+	}
+}
+
+// Unlocks the tournament, then seats the agents of the games just created
+
+func (t *Tournament) unlock() {
+	starting := t.starting
+	t.starting = nil
+	t.mutex.Unlock()
+
+	for _, start := range starting {
+		for seat, name := range start.seats {
+			player := start.session.AddPlayer(name, start.agentIds[seat])
+			if player == nil {
+				log.Printf("Tournament %s could not seat %s in game %s", t.ID, name, start.session.ID)
+				close(start.channels[seat])
+				continue
+			}
+			start.channels[seat] <- Assignment{start.session.ID, player.ID, player.Token}
+		}
+	}
+}
+
+// Blocks until the agent's next game starts. Returns false if the tournament
+// is over, the agent could not be seated, or the wait timed out or was
+// cancelled.
+
+func (t *Tournament) Wait(ctx context.Context, name string, agentID string) (Assignment, bool, error) {
+	t.mutex.Lock()
+
+	if !slices.Contains(t.Agents, name) || t.AgentIds[name] != agentID {
+		t.mutex.Unlock()
+		return Assignment{}, false, fmt.Errorf("agent not registered: %s", name)
+	}
+	if t.Finished {
+		t.mutex.Unlock()
+		return Assignment{}, false, nil
+	}
+
+	// A new wait replaces the previous one of the agent
+
+	if previous := t.waiting[name]; previous != nil {
+		close(previous)
+	}
+
+	channel := make(chan Assignment, 1)
+	t.waiting[name] = channel
+	if t.Started {
+		t.dispatch()
+	}
+	t.unlock()
+
+	select {
+	case assignment, ok := <-channel:
+		return assignment, ok, nil
+	case <-time.After(TournamentWaitTimeout):
+	case <-ctx.Done():
+	}
+
+	t.mutex.Lock()
+	waiting := t.waiting[name] == channel
+	if waiting {
+		delete(t.waiting, name)
+	}
+	t.mutex.Unlock()
+
+	if waiting {
+		return Assignment{}, false, nil
+	}
+
+	// The game started just before the timeout, and the assignment is on its
+	// way, or the wait was closed
+
+	assignment, ok := <-channel
+	return assignment, ok, nil
+}
+
+func (t *Tournament) Record(summary GameSummary) {
+	t.mutex.Lock()
+	defer t.unlock()
+
+	index := slices.IndexFunc(t.Games, func(game *TournamentGame) bool {
+		return game.GameID == summary.Id
+	})
+	if index < 0 {
+		return
+	}
+
+	game := t.Games[index]
+	game.Status = FINISHED
+
+	for _, winner := range summary.Winners {
+		game.Winners = append(game.Winners, game.Seats[winner])
+	}
+
+	game.Flowers = make([]uint, len(game.Seats))
+	for _, rank := range summary.Ranking {
+		game.Flowers[rank.Player] = rank.Flowers
+	}
+
+	for player, name := range game.Seats {
+		standing := t.standings[name]
+		standing.Played++
+		standing.Flowers += game.Flowers[player]
+		if slices.Contains(summary.Winners, player) {
+			standing.Wins++
+			standing.Points += 1.0 / float64(len(summary.Winners))
+		}
+	}
+
+	log.Printf("Tournament %s recorded game %s (winners: %v)", t.ID, game.GameID, game.Winners)
+
+	if game.Match != nil {
+		t.recordMatchGame(game)
+	}
+
+	t.advance()
+	t.dispatch()
+}
+
+// A match is decided once an agent wins a majority of the best-of-N games. Drawn
+// games do not count, and a match still undecided after twice as many games
+// goes to the agent with the most flowers, then to the better seed.
+
+func (t *Tournament) recordMatchGame(game *TournamentGame) {
+	match := game.Match
+	match.Games++
+
+	if len(game.Winners) == 1 {
+		match.Wins[slices.Index(match.Agents[:], game.Winners[0])]++
+	}
+
+	needed := t.BestOf/2 + 1
+	for i, wins := range match.Wins {
+		if wins >= needed {
+			match.Winner = match.Agents[i]
+		}
+	}
+
+	if match.Winner == "" && match.Games >= 2*t.BestOf {
+		match.Winner = match.Agents[0]
+		if t.matchFlowers(match, 1) > t.matchFlowers(match, 0) {
+			match.Winner = match.Agents[1]
+		}
+	}
+
+	if match.Winner == "" {
+		t.scheduleMatchGame(match)
+	}
+}
+
+func (t *Tournament) matchFlowers(match *Match, agent int) uint {
+	var flowers uint
+	for _, game := range t.Games {
+		if game.Match == match && game.Flowers != nil {
+			flowers += game.Flowers[slices.Index(game.Seats, match.Agents[agent])]
+		}
+	}
+	return flowers
+}
+
+func (t *Tournament) roundOver() bool {
+	return !slices.ContainsFunc(t.Games, func(game *TournamentGame) bool {
+		return game.Status != FINISHED
+	})
+}
+
+func (t *Tournament) advance() {
+	if !t.roundOver() {
+		return
+	}
+
+	switch t.Format {
+	case SWISS:
+		if t.Round < t.Rounds {
+			t.scheduleSwissRound()
+			return
+		}
+	case ELIMINATION:
+		if len(t.Matches) > 1 {
+			var winners []string
+			for _, match := range t.Matches {
+				winners = append(winners, match.Winner)
+			}
+			t.scheduleBracket(winners)
+			return
+		}
+	}
+
+	t.Finished = true
+	log.Printf("Tournament %s is over", t.ID)
+
+	for name, channel := range t.waiting {
+		close(channel)
+		delete(t.waiting, name)
+	}
+}
+
+func (t *Tournament) IsFinished() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.Finished
+}
+
+// Standings are sorted by points, then wins, then flowers
+
+func (t *Tournament) Standings() []Standing {
+	var standings []Standing
+	for _, name := range t.Agents {
+		standings = append(standings, *t.standings[name])
+	}
+
+	slices.SortStableFunc(standings, func(a, b Standing) int {
+		return cmp.Or(
+			cmp.Compare(b.Points, a.Points),
+			cmp.Compare(b.Wins, a.Wins),
+			cmp.Compare(b.Flowers, a.Flowers),
+		)
+	})
+
+	return standings
+}
+
+// A snapshot of the tournament, taken while no game result can change it
+
+func (t *Tournament) Report() json.RawMessage {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	report, _ := json.Marshal(map[string]any{
+		"tournament": t,
+		"standings":  t.Standings(),
+	})
+	return report
+}
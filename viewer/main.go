@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"image/color"
 	"slices"
+	"time"
 
 	"github.com/hajimehoshi/ebiten/v2"
 	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
@@ -170,12 +171,32 @@ func (viewer *Viewer) DrawInfo(screen *ebiten.Image, state *GameState) {
 		txtOp.GeoM.Translate(0, lineHeight)
 		txtOp.ColorScale.Reset()
 		txtOp.ColorScale.ScaleWithColor(PlayerColors[i])
-		text.Draw(screen, fmt.Sprintf("Player %d: %s (%d flowers)", i, player, state.PlayerResources[i]), Font, txtOp)
+		info := fmt.Sprintf("Player %d: %s (%d flowers)", i, player, state.PlayerResources[i])
+		if i < len(state.Clocks) {
+			info += fmt.Sprintf(" [%.1fs]", time.Duration(state.Clocks[i]).Seconds())
+		}
+		if rank := playerRank(state, i); rank != nil {
+			info += fmt.Sprintf(" #%d", rank.Rank)
+		}
+		text.Draw(screen, info, Font, txtOp)
 	}
 
 	txtOp.ColorScale.Reset()
 	txtOp.GeoM.Translate(0, lineHeight)
-	text.Draw(screen, fmt.Sprintf("Game over: %v", state.GameOver), Font, txtOp)
+	if state.GameOver {
+		text.Draw(screen, fmt.Sprintf("Game over: %s", state.EndReason), Font, txtOp)
+	} else {
+		text.Draw(screen, "Game over: false", Font, txtOp)
+	}
+}
+
+func playerRank(state *GameState, player int) *Rank {
+	for i, rank := range state.Ranking {
+		if rank.Player == player {
+			return &state.Ranking[i]
+		}
+	}
+	return nil
 }
 
 func (viewer *Viewer) Draw(screen *ebiten.Image) {